@@ -1,5 +1,5 @@
 module github.com/powerman/narada-go
 
-go 1.16
+go 1.21
 
 require golang.org/x/sys v0.0.0-20181107165924-66b7b1311ac8
@@ -0,0 +1,445 @@
+// Package backup creates and restores backups of Narada project in same
+// way as narada-backup tool does.
+//
+// Backup is a tar archive of project directory, excluding files matching
+// patterns in config/backup/exclude (one pattern per line, same
+// semantics as `tar --exclude-from`). First backup is a full one
+// (.backup/full.tar), next backups are incremental (.backup/incr.tar)
+// and contain only files changed since full backup.
+//
+// Each archive contains metadata (see Meta) as a last member with a list
+// of all project files and their checksums, which is used to verify
+// backup while restoring it.
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/powerman/narada-go/narada"
+)
+
+const (
+	backupDir = ".backup"
+	metaName  = "narada-backup.json" // Project files are named "./…".
+)
+
+// Compression is a compression method of backup archive.
+type Compression int
+
+// Compression methods. Zstd requires zstd(1) tool in $PATH.
+const (
+	None Compression = iota
+	Gzip
+	Zstd
+)
+
+var compressions = []Compression{None, Gzip, Zstd}
+
+// Ext returns archive file extension.
+func (c Compression) Ext() string {
+	switch c {
+	case Gzip:
+		return ".tar.gz"
+	case Zstd:
+		return ".tar.zst"
+	}
+	return ".tar"
+}
+
+// Options for Create.
+type Options struct {
+	// Full forces full backup even if there is existing full backup.
+	Full bool
+	// Compression to use for new archive.
+	Compression Compression
+	// Project to backup, nil means default project.
+	Project *narada.Project
+}
+
+// project returns p or default project if p is nil.
+func project(p *narada.Project) *narada.Project {
+	if p == nil {
+		return narada.DefaultProject()
+	}
+	return p
+}
+
+// Meta describes backup archive contents.
+type Meta struct {
+	Version string    `json:"version"` // Project VERSION.
+	Created time.Time `json:"created"`
+	// Base is Created of full backup for incremental backup.
+	Base  time.Time `json:"base,omitempty"`
+	Files []File    `json:"files"`
+}
+
+// Incremental reports is it incremental backup.
+func (m *Meta) Incremental() bool {
+	return !m.Base.IsZero()
+}
+
+// File describes project file (including directories and symlinks).
+type File struct {
+	Name    string      `json:"name"` // Like "./config/log/level".
+	Mode    os.FileMode `json:"mode"`
+	Size    int64       `json:"size,omitempty"`
+	ModTime time.Time   `json:"mtime"`
+	Link    string      `json:"link,omitempty"`   // Symlink target.
+	SHA256  string      `json:"sha256,omitempty"` // Regular file checksum.
+	// Stored is false if file contents wasn't changed since base
+	// backup and thus wasn't included in this archive.
+	Stored bool `json:"stored"`
+}
+
+func (f *File) sameAs(other *File) bool {
+	return f.Mode == other.Mode && f.Size == other.Size &&
+		f.ModTime.Equal(other.ModTime) && f.Link == other.Link
+}
+
+// Create creates backup under narada.ExclusiveLock and returns path to
+// created archive. If ctx carries exclusive lock (see
+// narada.WithExclusiveLock) it is used instead.
+//
+// If there is existing full backup (and opts.Full is false) it creates
+// incremental backup, replacing previous incremental backup. Otherwise
+// (or if full backup was created by narada-backup and thus has no
+// metadata) it creates full backup and removes all previous backups.
+func Create(ctx context.Context, opts Options) (string, error) {
+	p := project(opts.Project)
+	patterns, _, err := p.LookupConfigContext(ctx, "backup/exclude")
+	if err != nil {
+		return "", err
+	}
+	exclude, err := newExcluder(strings.Split(string(patterns), "\n"))
+	if err != nil {
+		return "", err
+	}
+
+	lock, err := p.ExclusiveLockContext(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer lock.UnLock()
+
+	version, err := ioutil.ReadFile(p.Path("VERSION"))
+	if err != nil {
+		return "", err
+	}
+	meta := &Meta{
+		Version: string(bytes.TrimSpace(version)),
+		Created: time.Now().UTC(),
+	}
+	var base *Meta
+	if full := findArchive(p, "full"); full != "" && !opts.Full {
+		// Full backup created by narada-backup has no metadata, so
+		// incremental backup can't be based on it.
+		base, err = ReadMeta(ctx, full)
+		if err != nil && !errors.Is(err, ErrNoMeta) {
+			return "", fmt.Errorf("read full backup: %w", err)
+		}
+		if base != nil {
+			meta.Base = base.Created
+		}
+	}
+
+	dir := p.Path(backupDir)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	tmp, err := ioutil.TempFile(dir, ".tmp.")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp.Name())
+	err = writeArchive(ctx, p.Root(), tmp, opts.Compression, meta, base, exclude)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if errClose := tmp.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return "", err
+	}
+
+	kind := "full"
+	if base != nil {
+		kind = "incr"
+	}
+	name := filepath.Join(dir, kind+opts.Compression.Ext())
+	if err = os.Rename(tmp.Name(), name); err != nil {
+		return "", err
+	}
+	for _, c := range compressions {
+		old := filepath.Join(dir, kind+c.Ext())
+		if old != name {
+			_ = os.Remove(old)
+		}
+		if kind == "full" {
+			_ = os.Remove(filepath.Join(dir, "incr"+c.Ext()))
+		}
+	}
+	return name, nil
+}
+
+// findArchive returns path to existing archive of given kind ("full"
+// or "incr") or empty string.
+func findArchive(p *narada.Project, kind string) string {
+	for _, c := range compressions {
+		name := p.Path(filepath.Join(backupDir, kind+c.Ext()))
+		if _, err := os.Stat(name); err == nil {
+			return name
+		}
+	}
+	return ""
+}
+
+func writeArchive(ctx context.Context, root string, f *os.File, c Compression, meta, base *Meta, exclude excluder) (err error) {
+	w, err := compress(ctx, f, c)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if errClose := w.Close(); err == nil {
+			err = errClose
+		}
+	}()
+	tw := tar.NewWriter(w)
+
+	baseFiles := make(map[string]*File)
+	if base != nil {
+		for i := range base.Files {
+			baseFiles[base.Files[i].Name] = &base.Files[i]
+		}
+	}
+
+	err = filepath.Walk(root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if path == root || path == f.Name() {
+			return nil
+		}
+		name := "./" + filepath.ToSlash(path[len(root)+1:])
+		if exclude.match(name) {
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() && !fi.IsDir() && fi.Mode()&os.ModeSymlink == 0 {
+			return nil // Like tar, ignore sockets, devices, etc.
+		}
+		file := File{Name: name, Mode: fi.Mode(), ModTime: fi.ModTime(), Stored: true}
+		if fi.Mode().IsRegular() {
+			file.Size = fi.Size()
+		}
+		if fi.Mode()&os.ModeSymlink != 0 {
+			if file.Link, err = os.Readlink(path); err != nil {
+				return err
+			}
+		}
+		if b, ok := baseFiles[name]; ok && fi.Mode().IsRegular() && file.sameAs(b) {
+			file.SHA256 = b.SHA256
+			file.Stored = false
+		} else if err = writeFile(tw, path, fi, &file); err != nil {
+			return err
+		}
+		meta.Files = append(meta.Files, file)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	buf, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:    metaName,
+		Mode:    0644,
+		Size:    int64(len(buf)),
+		ModTime: meta.Created,
+		Format:  tar.FormatPAX,
+	}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if _, err = tw.Write(buf); err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+func writeFile(tw *tar.Writer, path string, fi os.FileInfo, file *File) error {
+	hdr, err := tar.FileInfoHeader(fi, file.Link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = file.Name
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	hdr.Format = tar.FormatPAX // Keep sub-second ModTime.
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err = io.CopyN(io.MultiWriter(tw, h), f, fi.Size()); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	file.SHA256 = hex.EncodeToString(h.Sum(nil))
+	return nil
+}
+
+// ReadMeta returns metadata of backup archive.
+func ReadMeta(ctx context.Context, name string) (*Meta, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	r, err := decompress(ctx, f, compressionOf(name))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s: %w", name, ErrNoMeta)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		if hdr.Name == metaName {
+			return decodeMeta(name, tr)
+		}
+	}
+}
+
+// ErrNoMeta means archive doesn't contain metadata (i.e. it's either
+// not created by this package or truncated).
+var ErrNoMeta = errors.New("no backup metadata")
+
+func decodeMeta(name string, r io.Reader) (*Meta, error) {
+	var meta Meta
+	if err := json.NewDecoder(r).Decode(&meta); err != nil {
+		return nil, fmt.Errorf("%s: bad metadata: %w", name, err)
+	}
+	return &meta, nil
+}
+
+func compressionOf(name string) Compression {
+	for _, c := range compressions[1:] {
+		if strings.HasSuffix(name, c.Ext()) {
+			return c
+		}
+	}
+	return None
+}
+
+type nopWriteCloser struct{ io.Writer }
+
+func (nopWriteCloser) Close() error { return nil }
+
+func compress(ctx context.Context, w io.Writer, c Compression) (io.WriteCloser, error) {
+	switch c {
+	case None:
+		return nopWriteCloser{w}, nil
+	case Gzip:
+		return gzip.NewWriter(w), nil
+	case Zstd:
+		cmd := exec.CommandContext(ctx, "zstd", "-q", "-c")
+		cmd.Stdout = w
+		cmd.Stderr = os.Stderr
+		return startCmd(cmd, true)
+	}
+	return nil, fmt.Errorf("unknown compression %d", c)
+}
+
+func decompress(ctx context.Context, r io.Reader, c Compression) (io.ReadCloser, error) {
+	switch c {
+	case None:
+		return ioutil.NopCloser(r), nil
+	case Gzip:
+		return gzip.NewReader(r)
+	case Zstd:
+		cmd := exec.CommandContext(ctx, "zstd", "-q", "-d", "-c")
+		cmd.Stdin = r
+		cmd.Stderr = os.Stderr
+		return startCmd(cmd, false)
+	}
+	return nil, fmt.Errorf("unknown compression %d", c)
+}
+
+// cmdPipe is a pipe to cmd's stdin (or from cmd's stdout), Close waits
+// for cmd to finish.
+type cmdPipe struct {
+	io.Reader
+	io.Writer
+	pipe io.Closer // Stdin pipe.
+	cmd  *exec.Cmd
+}
+
+func startCmd(cmd *exec.Cmd, toStdin bool) (*cmdPipe, error) {
+	p := &cmdPipe{cmd: cmd}
+	if toStdin {
+		w, err := cmd.StdinPipe()
+		if err != nil {
+			return nil, err
+		}
+		p.Writer, p.pipe = w, w
+	} else {
+		r, err := cmd.StdoutPipe()
+		if err != nil {
+			return nil, err
+		}
+		p.Reader = r
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *cmdPipe) Close() error {
+	var err error
+	if p.Reader != nil {
+		_, _ = io.Copy(ioutil.Discard, p.Reader) // Let cmd finish.
+	} else {
+		err = p.pipe.Close()
+	}
+	if errWait := p.cmd.Wait(); err == nil {
+		err = errWait
+	}
+	return err
+}
@@ -0,0 +1,290 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/powerman/narada-go/narada"
+	"github.com/powerman/narada-go/narada/staging"
+)
+
+func TestExclude(t *testing.T) {
+	e, err := newExcluder([]string{"./.backup/*", "./.lock*", "", "*.swp", "[!a-c]x", `\*`, "var/[]]"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cases := []struct {
+		name string
+		want bool
+	}{
+		{"./.backup", false},
+		{"./.backup/full.tar", true},
+		{"./.backup/dir/file", true},
+		{"./sub/.backup/file", false},
+		{"./.lock", true},
+		{"./.lock.new", true},
+		{"./var/.lock", false},
+		{"./a.swp", true},
+		{"./var/tmp/a.swp", true},
+		{"./a.swp/x", false},
+		{"./dx", true},
+		{"./ax", false},
+		{"./var/dx", true},
+		{"./*", true},
+		{"./a", false},
+		{"./var/]", true},
+		{"./a/var/]", true},
+	}
+	for _, c := range cases {
+		if got := e.match(c.name); got != c.want {
+			t.Errorf("match(%q) = %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func names(meta *Meta, stored bool) []string {
+	var names []string
+	for _, f := range meta.Files {
+		if !stored || f.Stored {
+			names = append(names, f.Name)
+		}
+	}
+	return names
+}
+
+func writeTestFile(t *testing.T, name, data string) {
+	t.Helper()
+	name = narada.Path(name)
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func setUp(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{".backup", "config", "tmp", "var"} {
+		if err := os.RemoveAll(narada.Path(name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeTestFile(t, "VERSION", "1.2.3+example-1234567890\n")
+	writeTestFile(t, "config/backup/exclude", "./.backup/*\n./.lock*\n./tmp/*\n./.release/*\ntmp-*\n")
+	writeTestFile(t, "tmp/file", "tmp")
+	writeTestFile(t, "var/data", "data")
+	if err := os.Symlink("data", narada.Path("var/link")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCreate(t *testing.T) {
+	ctx := context.Background()
+	for _, c := range []Compression{None, Gzip, Zstd} {
+		c := c
+		t.Run(c.Ext(), func(t *testing.T) {
+			if _, err := exec.LookPath("zstd"); c == Zstd && err != nil {
+				t.Skip(err)
+			}
+			testCreate(ctx, t, c)
+		})
+	}
+}
+
+func testCreate(ctx context.Context, t *testing.T, c Compression) {
+	setUp(t)
+
+	name, err := Create(ctx, Options{Compression: c})
+	if err != nil {
+		t.Fatalf("Create(), err = %v", err)
+	}
+	if want := narada.Path(".backup/full" + c.Ext()); name != want {
+		t.Errorf("Create() = %q, want %q", name, want)
+	}
+	meta, err := ReadMeta(ctx, name)
+	if err != nil {
+		t.Fatalf("ReadMeta(), err = %v", err)
+	}
+	want := []string{
+		"./.backup",
+		"./VERSION",
+		"./config",
+		"./config/backup",
+		"./config/backup/exclude",
+		"./tmp",
+		"./var",
+		"./var/data",
+		"./var/link",
+	}
+	if got := names(meta, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("full: stored = %q, want %q", got, want)
+	}
+	if meta.Incremental() || meta.Version != "1.2.3+example-1234567890" {
+		t.Errorf("full: Incremental() = %v, Version = %q", meta.Incremental(), meta.Version)
+	}
+	for _, f := range meta.Files {
+		switch f.Name {
+		case "./var/data":
+			if f.Size != 4 || f.SHA256 != "3a6eb0790f39ac87c94f3856b2dd2c5d110e6811602261a9a923d3bb23adc8b7" {
+				t.Errorf("full: %+v", f)
+			}
+		case "./var/link":
+			if f.Link != "data" || f.Mode&os.ModeSymlink == 0 {
+				t.Errorf("full: %+v", f)
+			}
+		}
+	}
+
+	time.Sleep(10 * time.Millisecond) // Ensure different mtime.
+	writeTestFile(t, "var/new", "new")
+	writeTestFile(t, "var/data", "changed")
+	if err = os.Remove(narada.Path("VERSION")); err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, "VERSION", "1.2.3+example-1234567890\n") // Same contents, new mtime.
+
+	name, err = Create(ctx, Options{Compression: c})
+	if err != nil {
+		t.Fatalf("Create(), err = %v", err)
+	}
+	if want := narada.Path(".backup/incr" + c.Ext()); name != want {
+		t.Errorf("Create() = %q, want %q", name, want)
+	}
+	incr, err := ReadMeta(ctx, name)
+	if err != nil {
+		t.Fatalf("ReadMeta(), err = %v", err)
+	}
+	if !incr.Incremental() || !incr.Base.Equal(meta.Created) {
+		t.Errorf("incr: Base = %v, want %v", incr.Base, meta.Created)
+	}
+	want = []string{
+		"./.backup",
+		"./VERSION",
+		"./config",
+		"./config/backup",
+		"./tmp",
+		"./var",
+		"./var/data",
+		"./var/link",
+		"./var/new",
+	}
+	if got := names(incr, true); !reflect.DeepEqual(got, want) {
+		t.Errorf("incr: stored = %q, want %q", got, want)
+	}
+	if got := len(incr.Files); got != len(want)+1 {
+		t.Errorf("incr: len(Files) = %d, want %d", got, len(want)+1)
+	}
+
+	name, err = Create(ctx, Options{Full: true, Compression: None})
+	if err != nil {
+		t.Fatalf("Create(), err = %v", err)
+	}
+	if want := narada.Path(".backup/full.tar"); name != want {
+		t.Errorf("Create() = %q, want %q", name, want)
+	}
+	fis, err := ioutil.ReadDir(narada.Path(".backup"))
+	if err != nil || len(fis) != 1 {
+		t.Errorf("ReadDir(.backup) = %v, %v, want only full.tar", fis, err)
+	}
+}
+
+func TestCreateCancel(t *testing.T) {
+	setUp(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err := Create(ctx, Options{}); err != context.Canceled {
+		t.Errorf("Create(), err = %v, want %v", err, context.Canceled)
+	}
+	if _, err := ReadMeta(context.Background(), narada.Path("VERSION")); err == nil {
+		t.Errorf("ReadMeta(VERSION), err = nil")
+	}
+}
+
+func TestCreateProject(t *testing.T) {
+	p := staging.New(t, staging.Txtar(`
+-- VERSION --
+0.1.0
+-- var/other --
+other
+`))
+	ctx := context.Background()
+	name, err := Create(ctx, Options{Project: p})
+	if err != nil {
+		t.Fatalf("Create(), err = %v", err)
+	}
+	if want := p.Path(".backup/full.tar"); name != want {
+		t.Errorf("Create() = %q, want %q", name, want)
+	}
+	meta, err := ReadMeta(ctx, name)
+	if err != nil {
+		t.Fatalf("ReadMeta(), err = %v", err)
+	}
+	if meta.Version != "0.1.0" {
+		t.Errorf("Version = %q, want %q", meta.Version, "0.1.0")
+	}
+	if files := names(meta, true); !contains(files, "./var/other") {
+		t.Errorf("Files = %v, want ./var/other", files)
+	}
+}
+
+func TestCreateAfterLegacyFull(t *testing.T) {
+	p := staging.New(t, staging.Txtar(`
+-- VERSION --
+0.1.0
+-- var/other --
+other
+`))
+	// Full backup created by narada-backup has no metadata.
+	if err := os.MkdirAll(p.Path(".backup"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	if err := tw.WriteHeader(&tar.Header{Name: "./var/other", Mode: 0644, Size: 6}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tw.Write([]byte("other\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	legacy := p.Path(".backup/full.tar")
+	if err := ioutil.WriteFile(legacy, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	name, err := Create(ctx, Options{Project: p})
+	if err != nil {
+		t.Fatalf("Create(), err = %v", err)
+	}
+	if name != legacy {
+		t.Errorf("Create() = %q, want %q", name, legacy)
+	}
+	meta, err := ReadMeta(ctx, name)
+	if err != nil {
+		t.Fatalf("ReadMeta(), err = %v", err)
+	}
+	if meta.Incremental() {
+		t.Errorf("Incremental() = true, want full backup")
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
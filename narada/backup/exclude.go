@@ -0,0 +1,109 @@
+package backup
+
+import (
+	"regexp"
+	"strings"
+)
+
+// excluder matches names like "./dir/file" against patterns using same
+// rules as `tar --exclude-from` does by default: "*" and "?" match "/"
+// too and pattern may match either whole name or any part of name which
+// starts after "/".
+type excluder []*regexp.Regexp
+
+func newExcluder(patterns []string) (excluder, error) {
+	var e excluder
+	for _, pattern := range patterns {
+		if strings.TrimSpace(pattern) == "" {
+			continue
+		}
+		re, err := regexp.Compile(`\A` + globToRegexp(pattern) + `\z`)
+		if err != nil {
+			return nil, err
+		}
+		e = append(e, re)
+	}
+	return e, nil
+}
+
+func (e excluder) match(name string) bool {
+	for _, re := range e {
+		if re.MatchString(name) {
+			return true
+		}
+		for i := 0; i < len(name)-1; i++ {
+			if name[i] == '/' && name[i+1] != '/' && re.MatchString(name[i+1:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchPath reports is name or any of its parent directories excluded.
+func (e excluder) matchPath(name string) bool {
+	for {
+		if e.match(name) {
+			return true
+		}
+		i := strings.LastIndexByte(name, '/')
+		if i <= 1 { // Reached "./name".
+			return false
+		}
+		name = name[:i]
+	}
+}
+
+// globToRegexp converts fnmatch(3) pattern to regexp.
+func globToRegexp(pattern string) string {
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '*':
+			b.WriteString(`.*`)
+		case '?':
+			b.WriteString(`.`)
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+			}
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		case '[':
+			end := classEnd(pattern, i)
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : end]
+			b.WriteByte('[')
+			if class[0] == '!' || class[0] == '^' {
+				b.WriteByte('^')
+				class = class[1:]
+			}
+			b.WriteString(strings.NewReplacer(`\`, `\\`, `[`, `\[`).Replace(class))
+			b.WriteByte(']')
+			i = end
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	return b.String()
+}
+
+// classEnd returns index of "]" which closes character class started
+// at pattern[start] or -1.
+func classEnd(pattern string, start int) int {
+	i := start + 1
+	if i < len(pattern) && (pattern[i] == '!' || pattern[i] == '^') {
+		i++
+	}
+	if i < len(pattern) && pattern[i] == ']' {
+		i++
+	}
+	for ; i < len(pattern); i++ {
+		if pattern[i] == ']' {
+			return i
+		}
+	}
+	return -1
+}
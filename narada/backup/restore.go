@@ -0,0 +1,561 @@
+package backup
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/powerman/narada-go/narada"
+)
+
+// Errors.
+var (
+	ErrCorrupted    = errors.New("backup is corrupted")
+	ErrNewerVersion = errors.New("backup VERSION is newer than project VERSION")
+)
+
+// ChangeKind describes how restore will change project file.
+type ChangeKind int
+
+// Change kinds.
+const (
+	Added ChangeKind = iota
+	Modified
+	Removed
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Modified:
+		return "modified"
+	case Removed:
+		return "removed"
+	}
+	return "unknown"
+}
+
+// Change describes project file changed by restore.
+type Change struct {
+	Name string // Like "./config/log/level".
+	Kind ChangeKind
+	// typeChanged is true if file was replaced by file of other type
+	// (like directory by symlink).
+	typeChanged bool
+	// dir is true if removed file is a directory. It's removed after
+	// files in it, so it's kept if it contains excluded files.
+	dir bool
+}
+
+// RestoreOptions for Restore.
+type RestoreOptions struct {
+	// DryRun only verifies backup and returns changes which would be
+	// made by restore (under narada.SharedLock).
+	DryRun bool
+	// Project to restore, nil means default project.
+	Project *narada.Project
+}
+
+// Restore restores project from backup archive name (full or
+// incremental, full backup is expected in same directory as incremental
+// one) and returns all changes made in project.
+//
+// Before changing anything it verifies checksums of all files in
+// archive(s) and refuses to restore backup with VERSION newer than
+// current project's VERSION.
+//
+// Files matching config/backup/exclude (and files in .backup/ and lock
+// files) are never changed. Restore is made under narada.ExclusiveLock
+// (or lock carried by ctx, see narada.WithExclusiveLock) and all changes
+// are rolled back on error.
+func Restore(ctx context.Context, name string, opts RestoreOptions) ([]Change, error) {
+	p := project(opts.Project)
+	patterns, _, err := p.LookupConfigContext(ctx, "backup/exclude")
+	if err != nil {
+		return nil, err
+	}
+	exclude, err := newExcluder(append(strings.Split(string(patterns), "\n"), protected...))
+	if err != nil {
+		return nil, err
+	}
+
+	var lock narada.Lock
+	if opts.DryRun {
+		lock, err = p.SharedLockContext(ctx)
+	} else {
+		lock, err = p.ExclusiveLockContext(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer lock.UnLock()
+
+	archives, metas, err := openBackup(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	meta := metas[len(metas)-1]
+	if err = checkVersion(p, meta.Version); err != nil {
+		return nil, err
+	}
+
+	r := &restorer{
+		root:  p.Root(),
+		files: make(map[string]*File, len(meta.Files)),
+	}
+	for i := range meta.Files {
+		r.files[meta.Files[i].Name] = &meta.Files[i]
+	}
+	changes, err := r.diff(ctx, exclude)
+	if err != nil {
+		return nil, err
+	}
+
+	// Extract files from archive which stores latest version of them.
+	extract := make(map[string]string)
+	for _, c := range changes {
+		if c.Kind != Removed && !r.files[c.Name].Mode.IsDir() {
+			extract[c.Name] = archives[0]
+		}
+	}
+	if len(archives) > 1 {
+		for _, f := range meta.Files {
+			if _, ok := extract[f.Name]; ok && f.Stored {
+				extract[f.Name] = archives[1]
+			}
+		}
+	}
+	if !opts.DryRun {
+		if err = os.MkdirAll(p.Path(backupDir), 0755); err != nil {
+			return nil, err
+		}
+		if r.staging, err = ioutil.TempDir(p.Path(backupDir), ".restore."); err != nil {
+			return nil, err
+		}
+		defer os.RemoveAll(r.staging)
+	}
+	for i, archive := range archives {
+		want := make(map[string]bool)
+		for name, from := range extract {
+			want[name] = from == archive && !opts.DryRun
+		}
+		if err = r.verify(ctx, archive, metas[i], want); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DryRun {
+		return changes, nil
+	}
+
+	if r.rollback, err = ioutil.TempDir(p.Path(backupDir), ".rollback."); err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(r.rollback)
+	if err = r.apply(ctx, changes); err != nil {
+		if errUndo := r.undo(); errUndo != nil {
+			return nil, fmt.Errorf("%w (rollback failed: %v)", err, errUndo)
+		}
+		return nil, err
+	}
+	r.setDirTimes()
+	return changes, nil
+}
+
+// testHookApply is called before restoring each file.
+var testHookApply = func(Change) error { return nil }
+
+// protected are patterns for files which restore must never change.
+var protected = []string{"./" + backupDir + "/*", "./.lock", "./.lock.new"}
+
+// openBackup returns full archive and metadata (and incremental one, if
+// name is incremental).
+func openBackup(ctx context.Context, name string) (archives []string, metas []*Meta, err error) {
+	meta, err := ReadMeta(ctx, name)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	if !meta.Incremental() {
+		return []string{name}, []*Meta{meta}, nil
+	}
+	dir := filepath.Dir(name)
+	for _, c := range compressions {
+		full := filepath.Join(dir, "full"+c.Ext())
+		if _, err = os.Stat(full); err != nil {
+			continue
+		}
+		fullMeta, err := ReadMeta(ctx, full)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrCorrupted, err)
+		}
+		if !fullMeta.Created.Equal(meta.Base) {
+			return nil, nil, fmt.Errorf("%w: %s is not a base for %s", ErrCorrupted, full, name)
+		}
+		return []string{full, name}, []*Meta{fullMeta, meta}, nil
+	}
+	return nil, nil, fmt.Errorf("%w: no full backup for %s", ErrCorrupted, name)
+}
+
+func checkVersion(p *narada.Project, version string) error {
+	backupVer, err := narada.ParseProjectVersion(version)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrCorrupted, err)
+	}
+	buf, err := ioutil.ReadFile(p.Path("VERSION"))
+	if err != nil {
+		return err
+	}
+	curVer, err := narada.ParseProjectVersion(string(bytes.TrimSpace(buf)))
+	if err != nil {
+		return err
+	}
+	if curVer.Less(backupVer) {
+		return fmt.Errorf("%w: %s > %s", ErrNewerVersion, backupVer, curVer)
+	}
+	return nil
+}
+
+type restorer struct {
+	root     string
+	files    map[string]*File // Files in backup.
+	staging  string           // Extracted files.
+	rollback string           // Replaced files.
+	undoOps  []func() error
+}
+
+func (r *restorer) path(name string) string {
+	return filepath.Join(r.root, filepath.FromSlash(name))
+}
+
+// diff compares project with backup.
+func (r *restorer) diff(ctx context.Context, exclude excluder) ([]Change, error) {
+	var changes []Change
+	seen := make(map[string]bool)
+	kept := make(map[string]bool) // Dirs with files restore mustn't change.
+	err := filepath.Walk(r.root, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if err = ctx.Err(); err != nil {
+			return err
+		}
+		if path == r.root {
+			return nil
+		}
+		name := "./" + filepath.ToSlash(path[len(r.root)+1:])
+		if exclude.match(name) {
+			keepParents(kept, name)
+			if fi.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !fi.Mode().IsRegular() && !fi.IsDir() && fi.Mode()&os.ModeSymlink == 0 {
+			keepParents(kept, name)
+			return nil // Not in backup, not our business.
+		}
+		seen[name] = true
+		f, ok := r.files[name]
+		switch {
+		case !ok && fi.IsDir():
+			// Walk into it to keep excluded files.
+			changes = append(changes, Change{Name: name, Kind: Removed, dir: true})
+			return nil
+		case !ok:
+			changes = append(changes, Change{Name: name, Kind: Removed})
+		case f.Mode.Type() != fi.Mode().Type():
+			changes = append(changes, Change{Name: name, Kind: Modified, typeChanged: true})
+		default:
+			same, err := sameFile(path, fi, f)
+			if err != nil {
+				return err
+			}
+			if !same {
+				changes = append(changes, Change{Name: name, Kind: Modified})
+			}
+			return nil
+		}
+		if fi.IsDir() {
+			return filepath.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	all := changes
+	changes = changes[:0]
+	for _, c := range all {
+		if !(c.Kind == Removed && kept[c.Name]) {
+			changes = append(changes, c)
+		}
+	}
+	for name := range r.files {
+		if !seen[name] && !exclude.matchPath(name) {
+			changes = append(changes, Change{Name: name, Kind: Added})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes, nil
+}
+
+// keepParents adds to kept all parent directories of name.
+func keepParents(kept map[string]bool, name string) {
+	for i := strings.LastIndexByte(name, '/'); i > 1 && !kept[name[:i]]; i = strings.LastIndexByte(name, '/') {
+		name = name[:i]
+		kept[name] = true
+	}
+}
+
+func sameFile(path string, fi os.FileInfo, f *File) (bool, error) {
+	if fi.Mode().Perm() != f.Mode.Perm() && fi.Mode()&os.ModeSymlink == 0 {
+		return false, nil
+	}
+	switch {
+	case fi.Mode()&os.ModeSymlink != 0:
+		link, err := os.Readlink(path)
+		return link == f.Link, err
+	case fi.Mode().IsRegular():
+		if fi.Size() != f.Size {
+			return false, nil
+		}
+		sum, err := fileSHA256(path)
+		return sum == f.SHA256, err
+	}
+	return true, nil
+}
+
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err = io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// verify checks all files stored in archive and extracts files marked
+// in extract to staging.
+func (r *restorer) verify(ctx context.Context, archive string, meta *Meta, extract map[string]bool) error {
+	files := make(map[string]*File)
+	for i := range meta.Files {
+		if meta.Files[i].Stored {
+			files[meta.Files[i].Name] = &meta.Files[i]
+		}
+	}
+	f, err := os.Open(archive)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	rd, err := decompress(ctx, f, compressionOf(archive))
+	if err != nil {
+		return fmt.Errorf("%w: %s: %v", ErrCorrupted, archive, err)
+	}
+	defer rd.Close()
+	tr := tar.NewReader(rd)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("%w: %s: %v", ErrCorrupted, archive, err)
+		}
+		if hdr.Name == metaName {
+			continue
+		}
+		name := strings.TrimSuffix(hdr.Name, "/")
+		file, ok := files[name]
+		if !ok {
+			return fmt.Errorf("%w: %s: unexpected %s", ErrCorrupted, archive, name)
+		}
+		delete(files, name)
+		if err = r.verifyFile(tr, hdr, file, extract[name]); err != nil {
+			return fmt.Errorf("%w: %s: %s: %v", ErrCorrupted, archive, name, err)
+		}
+	}
+	if len(files) > 0 {
+		missing := make([]string, 0, len(files))
+		for name := range files {
+			missing = append(missing, name)
+		}
+		sort.Strings(missing)
+		return fmt.Errorf("%w: %s: missing %s", ErrCorrupted, archive, strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (r *restorer) verifyFile(tr *tar.Reader, hdr *tar.Header, file *File, extract bool) error {
+	if hdr.FileInfo().Mode().Type() != file.Mode.Type() {
+		return errors.New("type mismatch")
+	}
+	dst := filepath.Join(r.staging, filepath.FromSlash(file.Name))
+	if extract {
+		if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+			return err
+		}
+	}
+	switch {
+	case file.Mode&os.ModeSymlink != 0:
+		if hdr.Linkname != file.Link {
+			return errors.New("link mismatch")
+		}
+		if extract {
+			return os.Symlink(file.Link, dst)
+		}
+	case file.Mode.IsRegular():
+		w := ioutil.Discard
+		if extract {
+			out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+			if err != nil {
+				return err
+			}
+			defer out.Close()
+			w = out
+		}
+		h := sha256.New()
+		if _, err := io.Copy(io.MultiWriter(w, h), tr); err != nil {
+			return err
+		}
+		if hex.EncodeToString(h.Sum(nil)) != file.SHA256 {
+			return errors.New("checksum mismatch")
+		}
+		if extract {
+			if err := os.Chmod(dst, file.Mode.Perm()); err != nil {
+				return err
+			}
+			return os.Chtimes(dst, file.ModTime, file.ModTime)
+		}
+	}
+	return nil
+}
+
+// apply changes project, each change is recorded in undoOps.
+func (r *restorer) apply(ctx context.Context, changes []Change) error {
+	for _, c := range changes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		path := r.path(c.Name)
+		if c.Kind == Added || c.dir {
+			continue
+		}
+		if c.Kind == Modified && !c.typeChanged && r.files[c.Name].Mode.IsDir() {
+			fi, err := os.Stat(path)
+			if err != nil {
+				return err
+			}
+			if err = os.Chmod(path, r.files[c.Name].Mode.Perm()); err != nil {
+				return err
+			}
+			r.undoOps = append(r.undoOps, func() error { return os.Chmod(path, fi.Mode().Perm()) })
+			continue
+		}
+		saved := filepath.Join(r.rollback, filepath.FromSlash(c.Name))
+		if err := os.MkdirAll(filepath.Dir(saved), 0700); err != nil {
+			return err
+		}
+		if err := os.Rename(path, saved); err != nil {
+			return err
+		}
+		r.undoOps = append(r.undoOps, func() error { return os.Rename(saved, path) })
+	}
+	for i := len(changes) - 1; i >= 0; i-- {
+		if c := changes[i]; c.dir {
+			if err := r.removeDir(c.Name); err != nil {
+				return err
+			}
+		}
+	}
+	for _, c := range changes {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		f := r.files[c.Name]
+		if c.Kind == Removed || (c.Kind == Modified && !c.typeChanged && f.Mode.IsDir()) {
+			continue
+		}
+		if err := testHookApply(c); err != nil {
+			return err
+		}
+		path := r.path(c.Name)
+		if f.Mode.IsDir() {
+			if err := os.Mkdir(path, f.Mode.Perm()); err != nil {
+				return err
+			}
+			if err := os.Chmod(path, f.Mode.Perm()); err != nil { // Ignore umask.
+				return err
+			}
+		} else if err := os.Rename(filepath.Join(r.staging, filepath.FromSlash(c.Name)), path); err != nil {
+			return err
+		}
+		r.undoOps = append(r.undoOps, func() error { return os.Remove(path) })
+	}
+	return nil
+}
+
+// removeDir removes empty directory name (files in it must be already
+// moved to rollback).
+func (r *restorer) removeDir(name string) error {
+	path := r.path(name)
+	fi, err := os.Lstat(path)
+	if err != nil {
+		return err
+	}
+	if err = os.Remove(path); err != nil {
+		return err
+	}
+	r.undoOps = append(r.undoOps, func() error {
+		if err := os.Mkdir(path, fi.Mode().Perm()); err != nil {
+			return err
+		}
+		if err := os.Chmod(path, fi.Mode().Perm()); err != nil { // Ignore umask.
+			return err
+		}
+		return os.Chtimes(path, time.Now(), fi.ModTime())
+	})
+	return nil
+}
+
+// undo rolls back all changes made by apply.
+func (r *restorer) undo() error {
+	var errs []error
+	for i := len(r.undoOps) - 1; i >= 0; i-- {
+		if err := r.undoOps[i](); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// setDirTimes restores modification time of directories (it's changed
+// by restoring files in them).
+func (r *restorer) setDirTimes() {
+	names := make([]string, 0, len(r.files))
+	for name, f := range r.files {
+		if f.Mode.IsDir() {
+			names = append(names, name)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(names)))
+	for _, name := range names {
+		mtime := r.files[name].ModTime
+		_ = os.Chtimes(r.path(name), time.Now(), mtime)
+	}
+}
@@ -0,0 +1,250 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/powerman/narada-go/narada"
+	"github.com/powerman/narada-go/narada/internal/testutil"
+	"github.com/powerman/narada-go/narada/staging"
+)
+
+// modify changes project and returns changes which restore should do.
+func modify(t *testing.T) []Change {
+	t.Helper()
+	time.Sleep(10 * time.Millisecond) // Ensure different mtime.
+	writeTestFile(t, "var/data", "changed")
+	writeTestFile(t, "var/extra/file", "extra")
+	writeTestFile(t, "var/gone/dir/file", "gone")
+	writeTestFile(t, "var/gone/tmp-file", "excluded")
+	writeTestFile(t, "tmp/new", "excluded")
+	if err := os.Remove(narada.Path("var/link")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(narada.Path("config"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	return []Change{
+		{Name: "./config", Kind: Modified},
+		{Name: "./var/data", Kind: Modified},
+		{Name: "./var/extra", Kind: Removed, dir: true},
+		{Name: "./var/extra/file", Kind: Removed},
+		{Name: "./var/gone/dir", Kind: Removed, dir: true},
+		{Name: "./var/gone/dir/file", Kind: Removed},
+		{Name: "./var/link", Kind: Added},
+	}
+}
+
+func checkRestored(t *testing.T, data string) {
+	t.Helper()
+	if s := testutil.ReadFile(t, narada.Path("var/data")); s != data {
+		t.Errorf("var/data = %q, want %q", s, data)
+	}
+	if link, err := os.Readlink(narada.Path("var/link")); err != nil || link != "data" {
+		t.Errorf("var/link = %q, %v", link, err)
+	}
+	if _, err := os.Stat(narada.Path("var/extra")); !os.IsNotExist(err) {
+		t.Errorf("var/extra, err = %v", err)
+	}
+	if s := testutil.ReadFile(t, narada.Path("tmp/new")); s != "excluded" {
+		t.Errorf("tmp/new = %q, want %q", s, "excluded")
+	}
+	if _, err := os.Stat(narada.Path("var/gone/dir")); !os.IsNotExist(err) {
+		t.Errorf("var/gone/dir, err = %v", err)
+	}
+	if s := testutil.ReadFile(t, narada.Path("var/gone/tmp-file")); s != "excluded" {
+		t.Errorf("var/gone/tmp-file = %q, want %q", s, "excluded")
+	}
+	if fi, err := os.Stat(narada.Path("config")); err != nil || fi.Mode().Perm() != 0755 {
+		t.Errorf("config = %v, %v", fi, err)
+	}
+	fis, err := ioutil.ReadDir(narada.Path(".backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fi := range fis {
+		if fi.Name()[0] == '.' {
+			t.Errorf("not removed: .backup/%s", fi.Name())
+		}
+	}
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	setUp(t)
+	if err := os.Chmod(narada.Path("config"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	full, err := Create(ctx, Options{Compression: Gzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := modify(t)
+
+	changes, err := Restore(ctx, full, RestoreOptions{DryRun: true})
+	if err != nil {
+		t.Fatalf("Restore(DryRun), err = %v", err)
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Restore(DryRun) = %v, want %v", changes, want)
+	}
+	if s := testutil.ReadFile(t, narada.Path("var/data")); s != "changed" {
+		t.Errorf("var/data = %q, want unchanged", s)
+	}
+
+	changes, err = Restore(ctx, full, RestoreOptions{})
+	if err != nil {
+		t.Fatalf("Restore(), err = %v", err)
+	}
+	if !reflect.DeepEqual(changes, want) {
+		t.Errorf("Restore() = %v, want %v", changes, want)
+	}
+	checkRestored(t, "data")
+
+	changes, err = Restore(ctx, full, RestoreOptions{})
+	if err != nil || len(changes) != 0 {
+		t.Errorf("Restore() = %v, %v, want no changes", changes, err)
+	}
+}
+
+func TestRestoreIncremental(t *testing.T) {
+	ctx := context.Background()
+	setUp(t)
+	if _, err := Create(ctx, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond)
+	writeTestFile(t, "var/data", "incr")
+	incr, err := Create(ctx, Options{Compression: Gzip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	modify(t)
+
+	if _, err = Restore(ctx, incr, RestoreOptions{}); err != nil {
+		t.Fatalf("Restore(), err = %v", err)
+	}
+	checkRestored(t, "incr")
+}
+
+func TestRestoreProject(t *testing.T) {
+	ctx := context.Background()
+	p := staging.New(t, staging.Txtar(`
+-- VERSION --
+0.1.0
+-- var/other --
+other
+`))
+	full, err := Create(ctx, Options{Project: p})
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(10 * time.Millisecond) // Ensure different mtime.
+	if err = ioutil.WriteFile(p.Path("var/other"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	changes, err := Restore(ctx, full, RestoreOptions{Project: p})
+	if err != nil {
+		t.Fatalf("Restore(), err = %v", err)
+	}
+	if want := []Change{{Name: "./var/other", Kind: Modified}}; !reflect.DeepEqual(changes, want) {
+		t.Errorf("Restore() = %v, want %v", changes, want)
+	}
+	if buf, err := ioutil.ReadFile(p.Path("var/other")); err != nil || string(buf) != "other\n" {
+		t.Errorf("var/other = %q, %v, want restored", buf, err)
+	}
+}
+
+func TestRestoreErrors(t *testing.T) {
+	ctx := context.Background()
+	setUp(t)
+	if _, err := Create(ctx, Options{}); err != nil {
+		t.Fatal(err)
+	}
+	incr, err := Create(ctx, Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	incrBuf, err := ioutil.ReadFile(incr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	full, err := Create(ctx, Options{Full: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(incr, incrBuf, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Restore(ctx, incr, RestoreOptions{}); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Restore(wrong base), err = %v, want %v", err, ErrCorrupted)
+	}
+	if err = os.Remove(incr); err != nil {
+		t.Fatal(err)
+	}
+
+	want := modify(t)
+	buf, err := ioutil.ReadFile(full)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	writeTestFile(t, "VERSION", "1.2.2\n")
+	if _, err = Restore(ctx, full, RestoreOptions{}); !errors.Is(err, ErrNewerVersion) {
+		t.Errorf("Restore(newer), err = %v, want %v", err, ErrNewerVersion)
+	}
+	writeTestFile(t, "VERSION", "1.2.3+example-1234567890\n")
+
+	corrupted := append([]byte(nil), buf...)
+	for i := 0; i < len(buf); i += 512 { // Find contents of var/data.
+		if bytes.HasPrefix(buf[i:], []byte("data\x00")) {
+			corrupted[i] = 'D'
+		}
+	}
+	if bytes.Equal(corrupted, buf) {
+		t.Fatal("failed to corrupt")
+	}
+	if err = ioutil.WriteFile(full, corrupted, 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err = Restore(ctx, full, RestoreOptions{DryRun: true})
+	if !errors.Is(err, ErrCorrupted) || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("Restore(corrupted), err = %v, want %v", err, ErrCorrupted)
+	}
+	if err = ioutil.WriteFile(full, buf[:len(buf)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Restore(ctx, full, RestoreOptions{}); !errors.Is(err, ErrCorrupted) {
+		t.Errorf("Restore(truncated), err = %v, want %v", err, ErrCorrupted)
+	}
+	if err = ioutil.WriteFile(full, buf, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	failed := errors.New("failed")
+	testHookApply = func(c Change) error {
+		if c.Name == "./var/link" {
+			return failed
+		}
+		return nil
+	}
+	defer func() { testHookApply = func(Change) error { return nil } }()
+	if _, err = Restore(ctx, full, RestoreOptions{}); !errors.Is(err, failed) {
+		t.Errorf("Restore(), err = %v, want %v", err, failed)
+	}
+	changes, err := Restore(ctx, full, RestoreOptions{DryRun: true})
+	if err != nil || !reflect.DeepEqual(changes, want) {
+		t.Errorf("after rollback: Restore(DryRun) = %v, %v, want %v", changes, err, want)
+	}
+	if s := testutil.ReadFile(t, narada.Path("var/data")); s != "changed" {
+		t.Errorf("var/data = %q, want rolled back", s)
+	}
+}
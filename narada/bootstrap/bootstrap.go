@@ -4,19 +4,33 @@
 // Wait for lock for $NARADA_BOOTSTRAP_TIMEOUT seconds (float, 15.0 by default),
 // and then either terminate current program (default) or do nothing
 // (if $NARADA_BOOTSTRAP_GRACEFUL set to non-empty value).
+//
+// If $NARADA_BOOTSTRAP_SLOG set to non-empty value then narada.SlogHandler
+// will be installed as slog default handler (this also makes log package
+// output go to Narada log).
+//
+// Long-running programs should use Context, OnShutdown and Done to
+// release bootstrap lock when some process tries to get
+// narada.ExclusiveLock (e.g. to deploy or backup project) or on
+// SIGTERM/SIGINT.
 package bootstrap
 
 import (
 	"errors"
 	"log"
+	"log/slog"
 	"os"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/powerman/narada-go/narada"
 )
 
-var lock *narada.Lock
+var (
+	mu   sync.Mutex
+	lock *narada.Lock
+)
 
 const defaultTimeout = 15 * time.Second
 
@@ -24,6 +38,9 @@ func init() {
 	if err := initLock(); err != nil {
 		log.Fatalf("can't get bootstrap lock: %v", err)
 	}
+	if os.Getenv("NARADA_BOOTSTRAP_SLOG") != "" {
+		slog.SetDefault(slog.New(narada.NewSlogHandler()))
+	}
 }
 
 func initLock() error {
@@ -49,6 +66,8 @@ func initLock() error {
 
 // Lock try to acquire bootstrap lock.
 func Lock(wait time.Duration) error {
+	mu.Lock()
+	defer mu.Unlock()
 	if lock != nil {
 		return errors.New("bootstrap lock already acquired")
 	}
@@ -62,6 +81,8 @@ func Lock(wait time.Duration) error {
 
 // UnLock release bootstrap lock.
 func Unlock() error {
+	mu.Lock()
+	defer mu.Unlock()
 	if lock == nil {
 		return errors.New("bootstrap lock not acquired")
 	}
@@ -74,5 +95,7 @@ func Unlock() error {
 
 // HasLock returns true if bootstrap lock currently acquired.
 func HasLock() bool {
+	mu.Lock()
+	defer mu.Unlock()
 	return lock != nil
 }
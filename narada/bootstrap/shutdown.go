@@ -0,0 +1,172 @@
+package bootstrap
+
+import (
+	"context"
+	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/powerman/narada-go/narada"
+)
+
+// ShutdownTimeout limits time to run shutdown hooks. Bootstrap lock will
+// be released after this timeout even if some hook is still running.
+var ShutdownTimeout = 10 * time.Second
+
+var shutdown = newCoordinator(syscall.SIGTERM, syscall.SIGINT)
+
+// Delays between retries of failed narada.WaitExclusive.
+const (
+	waitRetryDelay    = time.Second
+	maxWaitRetryDelay = time.Minute
+)
+
+// Context returns context which will be cancelled when some process
+// tries to get narada.ExclusiveLock or on SIGTERM/SIGINT.
+//
+// First call to Context, OnShutdown or Done starts watching for these
+// events, so programs which doesn't use them keep default signal
+// handling. After shutdown second SIGTERM/SIGINT will terminate program
+// as usually.
+func Context() context.Context { return shutdown.context() }
+
+// OnShutdown registers hook to be called after Context is cancelled.
+// Hooks are called in reverse order (last registered is called first)
+// with a context which expires after ShutdownTimeout.
+func OnShutdown(hook func(ctx context.Context)) { shutdown.onShutdown(hook) }
+
+// Done returns a channel which is closed after all shutdown hooks were
+// called and bootstrap lock was released. Program should exit after
+// that, because it doesn't hold lock anymore.
+func Done() <-chan struct{} { return shutdown.doneChan() }
+
+type coordinator struct {
+	signals []os.Signal
+	wait    func(context.Context) error // narada.WaitExclusive
+	retry   time.Duration               // First delay before retrying failed wait.
+	once    sync.Once
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	hooks   []func(context.Context)
+	done    chan struct{}
+}
+
+func newCoordinator(signals ...os.Signal) *coordinator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &coordinator{
+		signals: signals,
+		wait:    narada.WaitExclusive,
+		retry:   waitRetryDelay,
+		ctx:     ctx,
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+}
+
+func (c *coordinator) start() {
+	c.once.Do(func() {
+		sigc := make(chan os.Signal, 1)
+		signal.Notify(sigc, c.signals...)
+		go func() {
+			<-c.ctx.Done() // Both for any event and for signal.Stop.
+			signal.Stop(sigc)
+		}()
+		go func() {
+			select {
+			case sig := <-sigc:
+				log.Printf("shutdown: got %v", sig)
+			case <-c.waitExclusive():
+			}
+			c.shutdown()
+		}()
+	})
+}
+
+// waitExclusive returns channel which is closed when some process tries
+// to get narada.ExclusiveLock or c.ctx is done. Failures to wait (like
+// no free inotify instances) doesn't mean shutdown was requested, so
+// they are logged and wait is retried.
+func (c *coordinator) waitExclusive() <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		delay := c.retry
+		for {
+			err := c.wait(c.ctx)
+			if err == nil || c.ctx.Err() != nil {
+				return
+			}
+			log.Printf("shutdown: failed to wait for exclusive lock (retry in %v): %v", delay, err)
+			select {
+			case <-time.After(delay):
+			case <-c.ctx.Done():
+				return
+			}
+			if delay *= 2; delay > maxWaitRetryDelay {
+				delay = maxWaitRetryDelay
+			}
+		}
+	}()
+	return done
+}
+
+func (c *coordinator) context() context.Context {
+	c.start()
+	return c.ctx
+}
+
+func (c *coordinator) onShutdown(hook func(context.Context)) {
+	c.start()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.hooks = append(c.hooks, hook)
+}
+
+func (c *coordinator) doneChan() <-chan struct{} {
+	c.start()
+	return c.done
+}
+
+func (c *coordinator) shutdown() {
+	c.cancel()
+
+	c.mu.Lock()
+	hooks := c.hooks
+	c.hooks = nil
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), ShutdownTimeout)
+	defer cancel()
+	if !runHooks(ctx, hooks) {
+		log.Printf("shutdown: hooks are not finished in %v", ShutdownTimeout)
+	}
+
+	if HasLock() {
+		if err := Unlock(); err != nil {
+			log.Printf("shutdown: failed to release bootstrap lock: %v", err)
+		}
+	}
+	close(c.done)
+}
+
+// runHooks calls hooks in reverse order and returns false if ctx is done
+// before all hooks are finished.
+func runHooks(ctx context.Context, hooks []func(context.Context)) bool {
+	for i := len(hooks) - 1; i >= 0; i-- {
+		finished := make(chan struct{})
+		go func(hook func(context.Context)) {
+			defer close(finished)
+			hook(ctx)
+		}(hooks[i])
+		select {
+		case <-finished:
+		case <-ctx.Done():
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package bootstrap
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/powerman/narada-go/narada"
+)
+
+func waitDone(t *testing.T, c *coordinator) {
+	t.Helper()
+	select {
+	case <-c.doneChan():
+	case <-time.After(5 * time.Second):
+		t.Fatal("shutdown is not finished")
+	}
+	if c.context().Err() == nil {
+		t.Errorf("context is not cancelled")
+	}
+	if HasLock() {
+		t.Errorf("HasLock() = true after shutdown")
+	}
+}
+
+func TestShutdownOnExclusiveLock(t *testing.T) {
+	if !HasLock() {
+		if err := Lock(time.Second); err != nil {
+			t.Fatal(err)
+		}
+	}
+	c := newCoordinator(syscall.SIGUSR1)
+	var mu sync.Mutex
+	var calls []int
+	for i := 1; i <= 3; i++ {
+		i := i
+		c.onShutdown(func(ctx context.Context) {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("hook %d: no deadline", i)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, i)
+		})
+	}
+	if err := c.context().Err(); err != nil {
+		t.Fatalf("context().Err() = %v", err)
+	}
+
+	excl, err := narada.ExclusiveLock(5 * time.Second)
+	if err != nil {
+		t.Fatalf("ExclusiveLock(), err = %v", err)
+	}
+	waitDone(t, c)
+	if err = excl.UnLock(); err != nil {
+		t.Errorf("UnLock(), err = %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if want := []int{3, 2, 1}; !reflect.DeepEqual(calls, want) {
+		t.Errorf("hooks called in order %v, want %v", calls, want)
+	}
+}
+
+func TestShutdownOnSignal(t *testing.T) {
+	if err := Lock(time.Second); err != nil {
+		t.Fatal(err)
+	}
+	defer func(timeout time.Duration) { ShutdownTimeout = timeout }(ShutdownTimeout)
+	ShutdownTimeout = 100 * time.Millisecond
+
+	c := newCoordinator(syscall.SIGUSR1)
+	block := make(chan struct{})
+	defer close(block)
+	called := make(chan struct{}, 1)
+	c.onShutdown(func(context.Context) { called <- struct{}{} })
+	c.onShutdown(func(context.Context) { <-block })
+
+	if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+		t.Fatal(err)
+	}
+	waitDone(t, c)
+	select {
+	case <-called:
+		t.Errorf("hook called after timeout")
+	default:
+	}
+}
+
+func TestWaitExclusiveRetry(t *testing.T) {
+	c := newCoordinator(syscall.SIGUSR1)
+	defer c.cancel()
+	c.retry = time.Millisecond
+	failed := errors.New("inotify_init1: too many open files")
+	retried := make(chan struct{})
+	calls := 0 // Wait is called sequentially.
+	c.wait = func(ctx context.Context) error {
+		if calls++; calls < 3 {
+			return failed
+		}
+		close(retried)
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	done := c.waitExclusive()
+	select {
+	case <-retried:
+	case <-done:
+		t.Fatalf("waitExclusive() is done after failed wait")
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wait is not retried")
+	}
+	select {
+	case <-done:
+		t.Errorf("waitExclusive() is done without exclusive lock")
+	case <-time.After(10 * c.retry):
+	}
+
+	c.cancel()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Errorf("waitExclusive() is not done after cancel")
+	}
+}
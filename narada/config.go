@@ -1,7 +1,8 @@
 package narada
 
 import (
-	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"io/ioutil"
@@ -17,115 +18,346 @@ const configDir = "config/"
 var invalidName = regexp.MustCompile(`(?:\A|/)[.][.]?/`)
 var validName = regexp.MustCompile(`\A(?:[\w.-]+/)*[\w.-]+\z`)
 
-var open = func(name string) (io.ReadCloser, error) {
-	return os.Open(name)
-}
-
 // FakeConfig make GetConfig() return values from configs (keys are config
 // names) instead of real files. If configs doesn't have a key for some
 // config file - it will work as usually, by reading real file.
-func FakeConfig(configs map[string]string) {
-	open = func(name string) (io.ReadCloser, error) {
+func FakeConfig(configs map[string]string) { defaultProject.FakeConfig(configs) }
+
+// FakeConfig works like package-level FakeConfig.
+func (p *Project) FakeConfig(configs map[string]string) {
+	p.open = func(name string) (io.ReadCloser, error) {
 		if content, ok := configs[name[len(configDir):]]; ok {
 			return ioutil.NopCloser(strings.NewReader(content)), nil
 		}
-		return os.Open(name)
+		return os.Open(p.Path(name))
 	}
 }
 
-// GetConfig returns contents of file "config/"+path.
-// If file not exists it will return nil without any error.
-// Panics on invalid config name.
-func GetConfig(path string) ([]byte, error) {
-	if invalidName.MatchString(path) || !validName.MatchString(path) {
-		panic("invalid config name: " + path)
+var (
+	// ErrConfigName is a reason for invalid config name.
+	ErrConfigName = errors.New("invalid config name")
+	// ErrConfigMissing is a reason for empty config when value is required.
+	ErrConfigMissing = errors.New("missing config value")
+	// ErrConfigMultiLine is a reason for config with more than one line.
+	ErrConfigMultiLine = errors.New("config contain more than one line")
+	// ErrConfigParse is a reason for config with value of wrong type.
+	ErrConfigParse = errors.New("failed to parse config value")
+	// ErrConfigRange is a reason for config with value out of range.
+	ErrConfigRange = errors.New("config value out of range")
+)
+
+// ConfigError describes invalid config name or value.
+// Use errors.Is(err, ErrConfig*) to check the reason.
+type ConfigError struct {
+	Path   string // Config name (without "config/" prefix).
+	Reason error  // One of ErrConfig*.
+	Want   string // Required value, like "integer" or "integer >= 1".
+}
+
+func (e *ConfigError) Error() string {
+	switch e.Reason {
+	case ErrConfigName:
+		return "invalid config name: " + e.Path
+	case ErrConfigMultiLine:
+		return "config " + e.Path + " contain more than one line"
 	}
-	lock, err := SharedLock(0)
+	return "config " + e.Path + " must contain " + e.Want
+}
+
+func (e *ConfigError) Unwrap() error {
+	return e.Reason
+}
+
+// configReader implements config getters on top of lookup func.
+type configReader struct {
+	dir    string // Prefix for config names in errors.
+	lookup func(path string) ([]byte, bool, error)
+}
+
+func (p *Project) lookupConfigFile(path string) ([]byte, bool, error) {
+	return p.lookupConfigFileContext(context.Background(), path)
+}
+
+func (p *Project) lookupConfigFileContext(ctx context.Context, path string) ([]byte, bool, error) {
+	lock, err := p.SharedLockContext(ctx)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	defer lock.UnLock()
-	file, err := open(configDir + path)
+	return p.readConfigFile(path)
+}
+
+// readConfigFile works like lookupConfigFile but caller must hold lock.
+func (p *Project) readConfigFile(path string) ([]byte, bool, error) {
+	file, err := p.open(configDir + path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			return nil, nil
+			return nil, false, nil
 		}
-		return nil, err
+		return nil, false, err
 	}
 	defer file.Close()
-	return ioutil.ReadAll(file)
+	buf, err := ioutil.ReadAll(file)
+	if err != nil {
+		return buf, false, err
+	}
+	return buf, true, nil
 }
 
+// GetConfig returns contents of file "config/"+path.
+// If file not exists it will return nil without any error.
+// Panics on invalid config name.
+func GetConfig(path string) ([]byte, error) { return defaultProject.GetConfig(path) }
+
+// LookupConfig returns contents of file "config/"+path and true if file
+// exists. Returns *ConfigError on invalid config name.
+func LookupConfig(path string) ([]byte, bool, error) { return defaultProject.LookupConfig(path) }
+
 // GetConfigLine returns first line of file "config/"+path.
 // If file not exists it will return empty string.
 // Panics if unable to read file or it contains more than one line.
-func GetConfigLine(path string) string {
-	cfg, err := GetConfig(path)
-	if err != nil {
-		panic(err)
-	}
-	if cfg == nil {
-		return ""
-	}
-	if n := bytes.IndexByte(cfg, byte('\n')); n >= 0 {
-		if len(bytes.TrimSpace(cfg[n:])) != 0 {
-			panic("config " + path + " contain more than one line")
-		}
-		cfg = cfg[:n]
-	}
-	return string(cfg)
+func GetConfigLine(path string) string { return defaultProject.GetConfigLine(path) }
+
+// LookupConfigLine returns first line of file "config/"+path and true if
+// file exists. Returns *ConfigError if it contains more than one line.
+func LookupConfigLine(path string) (string, bool, error) {
+	return defaultProject.LookupConfigLine(path)
 }
 
 // GetConfigInt returns integer from first line of file "config/"+path.
 // If file not exists or empty it will return 0.
 // Panics if unable to read file or it contains more than one line or
 // that line doesn't contain one integer.
-func GetConfigInt(path string) int {
-	str := GetConfigLine(path)
-	if str == "" {
-		return 0
+func GetConfigInt(path string) int { return defaultProject.GetConfigInt(path) }
+
+// LookupConfigInt returns integer from first line of file "config/"+path
+// and true if file exists. If file is empty it will return 0.
+// Returns *ConfigError if file contains more than one line or that line
+// doesn't contain one integer.
+func LookupConfigInt(path string) (int, bool, error) { return defaultProject.LookupConfigInt(path) }
+
+// GetConfigIntBetween panics if value returned by GetConfigInt(path)
+// is less than min or greater than max.
+func GetConfigIntBetween(path string, min, max int) int {
+	return defaultProject.GetConfigIntBetween(path, min, max)
+}
+
+// LookupConfigIntBetween works like LookupConfigInt but also returns
+// *ConfigError if existing value is less than min or greater than max.
+func LookupConfigIntBetween(path string, min, max int) (int, bool, error) {
+	return defaultProject.LookupConfigIntBetween(path, min, max)
+}
+
+// GetConfigDuration returns duration parsed from first line of file "config/"+path.
+// Panics if file not exists or empty or unable to read file or
+// file contains more than one line or that line doesn't contain duration
+// (see time.ParseDuration).
+func GetConfigDuration(path string) time.Duration { return defaultProject.GetConfigDuration(path) }
+
+// LookupConfigDuration returns duration parsed from first line of file
+// "config/"+path and true if file exists.
+// Returns *ConfigError if file is empty or contains more than one line or
+// that line doesn't contain duration (see time.ParseDuration).
+func LookupConfigDuration(path string) (time.Duration, bool, error) {
+	return defaultProject.LookupConfigDuration(path)
+}
+
+// GetConfigDurationBetween panics if value returned by GetConfigDuration(path)
+// is less than min or greater than max.
+func GetConfigDurationBetween(path string, min, max time.Duration) time.Duration {
+	return defaultProject.GetConfigDurationBetween(path, min, max)
+}
+
+// LookupConfigDurationBetween works like LookupConfigDuration but also
+// returns *ConfigError if existing value is less than min or greater
+// than max.
+func LookupConfigDurationBetween(path string, min, max time.Duration) (time.Duration, bool, error) {
+	return defaultProject.LookupConfigDurationBetween(path, min, max)
+}
+
+// GetConfigContext works like GetConfig but gets shared lock using
+// SharedLockContext(ctx), so it may be called while ctx carries lock (see
+// WithSharedLock and WithExclusiveLock). Use ReadConfigSnapshotContext
+// to get other config getters working in same way.
+func GetConfigContext(ctx context.Context, path string) ([]byte, error) {
+	return defaultProject.GetConfigContext(ctx, path)
+}
+
+// GetConfigContext works like package-level GetConfigContext.
+func (p *Project) GetConfigContext(ctx context.Context, path string) ([]byte, error) {
+	return p.readerContext(ctx).GetConfig(path)
+}
+
+// LookupConfigContext works like LookupConfig but gets shared lock in
+// same way as GetConfigContext.
+func LookupConfigContext(ctx context.Context, path string) ([]byte, bool, error) {
+	return defaultProject.LookupConfigContext(ctx, path)
+}
+
+// LookupConfigContext works like package-level LookupConfigContext.
+func (p *Project) LookupConfigContext(ctx context.Context, path string) ([]byte, bool, error) {
+	return p.readerContext(ctx).LookupConfig(path)
+}
+
+func (p *Project) readerContext(ctx context.Context) configReader {
+	return configReader{lookup: func(path string) ([]byte, bool, error) {
+		return p.lookupConfigFileContext(ctx, path)
+	}}
+}
+
+// GetConfig works like package-level GetConfig.
+func (r configReader) GetConfig(path string) ([]byte, error) {
+	buf, _, err := r.LookupConfig(path)
+	if errors.Is(err, ErrConfigName) {
+		panic(err.Error())
+	}
+	return buf, err
+}
+
+// LookupConfig works like package-level LookupConfig.
+func (r configReader) LookupConfig(path string) ([]byte, bool, error) {
+	if invalidName.MatchString(path) || !validName.MatchString(path) {
+		return nil, false, &ConfigError{Path: r.dir + path, Reason: ErrConfigName}
+	}
+	return r.lookup(path)
+}
+
+// GetConfigLine works like package-level GetConfigLine.
+func (r configReader) GetConfigLine(path string) string {
+	line, _, err := r.LookupConfigLine(path)
+	if err != nil {
+		panicConfig(err)
+	}
+	return line
+}
+
+// LookupConfigLine works like package-level LookupConfigLine.
+func (r configReader) LookupConfigLine(path string) (string, bool, error) {
+	cfg, ok, err := r.LookupConfig(path)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	line, err := firstLine(r.dir+path, cfg)
+	if err != nil {
+		return "", true, err
+	}
+	return line, true, nil
+}
+
+// GetConfigInt works like package-level GetConfigInt.
+func (r configReader) GetConfigInt(path string) int {
+	i, _, err := r.LookupConfigInt(path)
+	if err != nil {
+		panicConfig(err)
+	}
+	return i
+}
+
+// LookupConfigInt works like package-level LookupConfigInt.
+func (r configReader) LookupConfigInt(path string) (int, bool, error) {
+	str, ok, err := r.LookupConfigLine(path)
+	if err != nil || str == "" {
+		return 0, ok, err
 	}
 	i, err := strconv.Atoi(strings.TrimSpace(str))
 	if err != nil {
-		panic("config " + path + " must contain integer")
+		return 0, true, &ConfigError{Path: r.dir + path, Reason: ErrConfigParse, Want: "integer"}
+	}
+	return i, true, nil
+}
+
+// GetConfigIntBetween works like package-level GetConfigIntBetween.
+func (r configReader) GetConfigIntBetween(path string, min, max int) int {
+	i, err := checkIntBetween(r.dir+path, r.GetConfigInt(path), min, max)
+	if err != nil {
+		panicConfig(err)
 	}
 	return i
 }
 
-// GetConfigIntBetween panics if value returned by GetConfigInt(path)
-// is less than min or greater than max.
-func GetConfigIntBetween(path string, min, max int) int {
-	i := GetConfigInt(path)
+// LookupConfigIntBetween works like package-level LookupConfigIntBetween.
+func (r configReader) LookupConfigIntBetween(path string, min, max int) (int, bool, error) {
+	i, ok, err := r.LookupConfigInt(path)
+	if err != nil || !ok {
+		return i, ok, err
+	}
+	i, err = checkIntBetween(r.dir+path, i, min, max)
+	return i, true, err
+}
+
+func checkIntBetween(path string, i, min, max int) (int, error) {
 	if i < min {
-		panic(fmt.Sprintf("config %s must contain integer >= %d", path, min))
+		return 0, &ConfigError{Path: path, Reason: ErrConfigRange, Want: fmt.Sprintf("integer >= %d", min)}
 	}
 	if i > max {
-		panic(fmt.Sprintf("config %s must contain integer <= %d", path, max))
+		return 0, &ConfigError{Path: path, Reason: ErrConfigRange, Want: fmt.Sprintf("integer <= %d", max)}
 	}
-	return i
+	return i, nil
 }
 
-// GetConfigDuration returns duration parsed from first line of file "config/"+path.
-// Panics if file not exists or empty or unable to read file or
-// file contains more than one line or that line doesn't contain duration
-// (see time.ParseDuration).
-func GetConfigDuration(path string) time.Duration {
-	d, err := time.ParseDuration(GetConfigLine(path))
+// GetConfigDuration works like package-level GetConfigDuration.
+func (r configReader) GetConfigDuration(path string) time.Duration {
+	d, ok, err := r.LookupConfigDuration(path)
+	if err == nil && !ok {
+		err = &ConfigError{Path: r.dir + path, Reason: ErrConfigMissing, Want: "duration"}
+	}
 	if err != nil {
-		panic("config " + path + " must contain duration")
+		panicConfig(err)
 	}
 	return d
 }
 
-// GetConfigDurationBetween panics if value returned by GetConfigDuration(path)
-// is less than min or greater than max.
-func GetConfigDurationBetween(path string, min, max time.Duration) time.Duration {
-	d := GetConfigDuration(path)
+// LookupConfigDuration works like package-level LookupConfigDuration.
+func (r configReader) LookupConfigDuration(path string) (time.Duration, bool, error) {
+	str, ok, err := r.LookupConfigLine(path)
+	if err != nil || !ok {
+		return 0, ok, err
+	}
+	if str == "" {
+		return 0, true, &ConfigError{Path: r.dir + path, Reason: ErrConfigMissing, Want: "duration"}
+	}
+	d, err := time.ParseDuration(str)
+	if err != nil {
+		return 0, true, &ConfigError{Path: r.dir + path, Reason: ErrConfigParse, Want: "duration"}
+	}
+	return d, true, nil
+}
+
+// GetConfigDurationBetween works like package-level GetConfigDurationBetween.
+func (r configReader) GetConfigDurationBetween(path string, min, max time.Duration) time.Duration {
+	d, err := checkDurationBetween(r.dir+path, r.GetConfigDuration(path), min, max)
+	if err != nil {
+		panicConfig(err)
+	}
+	return d
+}
+
+// LookupConfigDurationBetween works like package-level LookupConfigDurationBetween.
+func (r configReader) LookupConfigDurationBetween(path string, min, max time.Duration) (time.Duration, bool, error) {
+	d, ok, err := r.LookupConfigDuration(path)
+	if err != nil || !ok {
+		return d, ok, err
+	}
+	d, err = checkDurationBetween(r.dir+path, d, min, max)
+	return d, true, err
+}
+
+func checkDurationBetween(path string, d, min, max time.Duration) (time.Duration, error) {
 	if d < min {
-		panic(fmt.Sprintf("config %s must contain duration >= %s", path, min))
+		return 0, &ConfigError{Path: path, Reason: ErrConfigRange, Want: fmt.Sprintf("duration >= %s", min)}
 	}
 	if d > max {
-		panic(fmt.Sprintf("config %s must contain duration <= %s", path, max))
+		return 0, &ConfigError{Path: path, Reason: ErrConfigRange, Want: fmt.Sprintf("duration <= %s", max)}
 	}
-	return d
+	return d, nil
+}
+
+// panicConfig panics with error message for *ConfigError (for
+// compatibility with panics used before ConfigError was introduced) or
+// with err itself for other errors.
+func panicConfig(err error) {
+	if cerr := (*ConfigError)(nil); errors.As(err, &cerr) {
+		panic(err.Error())
+	}
+	panic(err)
 }
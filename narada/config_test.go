@@ -2,6 +2,7 @@ package narada
 
 import (
 	"bytes"
+	"errors"
 	"fmt"
 	"os"
 	"syscall"
@@ -28,8 +29,8 @@ func TestFakeConfig(t *testing.T) {
 				{"nosuch", nil, nil},
 				{"fake", []byte("FAKE1"), nil},
 				{"file", []byte("REAL1"), nil},
-				{"unreadable", nil, &os.PathError{Op: "open", Path: "config/unreadable", Err: syscall.EACCES}},
-				{"dir", []byte{}, &os.PathError{Op: "read", Path: "config/dir", Err: syscall.EISDIR}},
+				{"unreadable", nil, &os.PathError{Op: "open", Path: Path("config/unreadable"), Err: syscall.EACCES}},
+				{"dir", []byte{}, &os.PathError{Op: "read", Path: Path("config/dir"), Err: syscall.EISDIR}},
 				{"dir/nosuch", nil, nil},
 				{"dir/fake", []byte("Fake\n2\n"), nil},
 				{"dir/file", []byte("Real2\n"), nil},
@@ -44,8 +45,8 @@ func TestFakeConfig(t *testing.T) {
 				{"fake", nil, nil},
 				{"fake2", []byte("FAKE2\n"), nil},
 				{"file", []byte("REAL1"), nil},
-				{"unreadable", nil, &os.PathError{Op: "open", Path: "config/unreadable", Err: syscall.EACCES}},
-				{"dir", []byte{}, &os.PathError{Op: "read", Path: "config/dir", Err: syscall.EISDIR}},
+				{"unreadable", nil, &os.PathError{Op: "open", Path: Path("config/unreadable"), Err: syscall.EACCES}},
+				{"dir", []byte{}, &os.PathError{Op: "read", Path: Path("config/dir"), Err: syscall.EISDIR}},
 				{"dir/nosuch", nil, nil},
 				{"dir/fake", nil, nil},
 				{"dir/file", []byte("Real2\n"), nil},
@@ -58,15 +59,15 @@ func TestFakeConfig(t *testing.T) {
 				{"fake", nil, nil},
 				{"fake2", nil, nil},
 				{"file", []byte("REAL1"), nil},
-				{"unreadable", nil, &os.PathError{Op: "open", Path: "config/unreadable", Err: syscall.EACCES}},
-				{"dir", []byte{}, &os.PathError{Op: "read", Path: "config/dir", Err: syscall.EISDIR}},
+				{"unreadable", nil, &os.PathError{Op: "open", Path: Path("config/unreadable"), Err: syscall.EACCES}},
+				{"dir", []byte{}, &os.PathError{Op: "read", Path: Path("config/dir"), Err: syscall.EISDIR}},
 				{"dir/nosuch", nil, nil},
 				{"dir/fake", nil, nil},
 				{"dir/file", []byte("Real2\n"), nil},
 			},
 		},
 	}
-	origOpen := open
+	origOpen := defaultProject.open
 	for _, c := range cases {
 		FakeConfig(c.fake)
 		for _, c := range c.configs {
@@ -79,7 +80,7 @@ func TestFakeConfig(t *testing.T) {
 			}
 		}
 	}
-	open = origOpen
+	defaultProject.open = origOpen
 }
 
 func TestGetConfig(t *testing.T) {
@@ -91,8 +92,8 @@ func TestGetConfig(t *testing.T) {
 		{"nosuch", nil, nil},
 		{"empty", []byte{}, nil},
 		{"file", []byte("REAL1"), nil},
-		{"unreadable", nil, &os.PathError{Op: "open", Path: "config/unreadable", Err: syscall.EACCES}},
-		{"log", []byte{}, &os.PathError{Op: "read", Path: "config/log", Err: syscall.EISDIR}},
+		{"unreadable", nil, &os.PathError{Op: "open", Path: Path("config/unreadable"), Err: syscall.EACCES}},
+		{"log", []byte{}, &os.PathError{Op: "read", Path: Path("config/log"), Err: syscall.EISDIR}},
 		{"log/nosuch", nil, nil},
 		{"log/level", []byte("INFO\n"), nil},
 		{"log/no-such_dir.123/no-such_file.123", nil, nil},
@@ -156,7 +157,7 @@ func TestGetConfigLineBad(t *testing.T) {
 		path    string
 		wantpnk interface{}
 	}{
-		{"log", &os.PathError{Op: "read", Path: "config/log", Err: syscall.EISDIR}},
+		{"log", &os.PathError{Op: "read", Path: Path("config/log"), Err: syscall.EISDIR}},
 		{"multi_line", "config multi_line contain more than one line"},
 	}
 	for _, c := range cases {
@@ -333,3 +334,92 @@ func TestGetConfigDurationBetweenBad(t *testing.T) {
 		}
 	}
 }
+
+func TestLookupConfig(t *testing.T) {
+	cases := []struct {
+		path    string
+		want    []byte
+		wantok  bool
+		wanterr error
+	}{
+		{"nosuch", nil, false, nil},
+		{"empty", []byte{}, true, nil},
+		{"file", []byte("REAL1"), true, nil},
+		{"unreadable", nil, false, &os.PathError{Op: "open", Path: Path("config/unreadable"), Err: syscall.EACCES}},
+		{"log/../empty", nil, false, &ConfigError{Path: "log/../empty", Reason: ErrConfigName}},
+	}
+	for _, c := range cases {
+		buf, ok, err := LookupConfig(c.path)
+		if (buf == nil) != (c.want == nil) || !bytes.Equal(buf, c.want) || ok != c.wantok {
+			t.Errorf("LookupConfig(%q) = %#v, %v, want = %#v, %v", c.path, buf, ok, c.want, c.wantok)
+		}
+		if fmt.Sprintf("%#v", err) != fmt.Sprintf("%#v", c.wanterr) {
+			t.Errorf("LookupConfig(%q), err = %#v, want %#v", c.path, err, c.wanterr)
+		}
+	}
+}
+
+func TestLookupConfigTyped(t *testing.T) {
+	lineErr := func(path string) error { return &ConfigError{Path: path, Reason: ErrConfigMultiLine} }
+	parseErr := func(path, want string) error { return &ConfigError{Path: path, Reason: ErrConfigParse, Want: want} }
+	rangeErr := func(path, want string) error { return &ConfigError{Path: path, Reason: ErrConfigRange, Want: want} }
+	cases := []struct {
+		name    string
+		lookup  func() (interface{}, bool, error)
+		want    interface{}
+		wantok  bool
+		wanterr error
+	}{
+		{"line nosuch", func() (interface{}, bool, error) { return LookupConfigLine("nosuch") }, "", false, nil},
+		{"line empty", func() (interface{}, bool, error) { return LookupConfigLine("empty") }, "", true, nil},
+		{"line single", func() (interface{}, bool, error) { return LookupConfigLine("single_line") }, "line1", true, nil},
+		{"line multi", func() (interface{}, bool, error) { return LookupConfigLine("multi_line") }, "", true, lineErr("multi_line")},
+		{"int nosuch", func() (interface{}, bool, error) { return LookupConfigInt("nosuch") }, 0, false, nil},
+		{"int empty", func() (interface{}, bool, error) { return LookupConfigInt("empty") }, 0, true, nil},
+		{"int", func() (interface{}, bool, error) { return LookupConfigInt("int") }, 42, true, nil},
+		{"int multi", func() (interface{}, bool, error) { return LookupConfigInt("multi_line") }, 0, true, lineErr("multi_line")},
+		{"int bad", func() (interface{}, bool, error) { return LookupConfigInt("badint") }, 0, true, parseErr("badint", "integer")},
+		{"int between nosuch", func() (interface{}, bool, error) { return LookupConfigIntBetween("nosuch", 1, 5) }, 0, false, nil},
+		{"int between", func() (interface{}, bool, error) { return LookupConfigIntBetween("int", 42, 42) }, 42, true, nil},
+		{"int between min", func() (interface{}, bool, error) { return LookupConfigIntBetween("int", 43, 45) }, 0, true, rangeErr("int", "integer >= 43")},
+		{"int between max", func() (interface{}, bool, error) { return LookupConfigIntBetween("int", 40, 41) }, 0, true, rangeErr("int", "integer <= 41")},
+		{"int between bad", func() (interface{}, bool, error) { return LookupConfigIntBetween("float", 0, 100) }, 0, true, parseErr("float", "integer")},
+		{"duration nosuch", func() (interface{}, bool, error) { return LookupConfigDuration("nosuch") }, time.Duration(0), false, nil},
+		{"duration empty", func() (interface{}, bool, error) { return LookupConfigDuration("empty") }, time.Duration(0), true, &ConfigError{Path: "empty", Reason: ErrConfigMissing, Want: "duration"}},
+		{"duration", func() (interface{}, bool, error) { return LookupConfigDuration("duration") }, 3 * time.Second, true, nil},
+		{"duration bad", func() (interface{}, bool, error) { return LookupConfigDuration("badint") }, time.Duration(0), true, parseErr("badint", "duration")},
+		{"duration between nosuch", func() (interface{}, bool, error) { return LookupConfigDurationBetween("nosuch", time.Second, time.Minute) }, time.Duration(0), false, nil},
+		{"duration between", func() (interface{}, bool, error) { return LookupConfigDurationBetween("duration", 0, time.Minute) }, 3 * time.Second, true, nil},
+		{"duration between min", func() (interface{}, bool, error) { return LookupConfigDurationBetween("duration", 4*time.Second, time.Minute) }, time.Duration(0), true, rangeErr("duration", "duration >= 4s")},
+		{"duration between max", func() (interface{}, bool, error) { return LookupConfigDurationBetween("duration", 0, 2*time.Second) }, time.Duration(0), true, rangeErr("duration", "duration <= 2s")},
+	}
+	for _, c := range cases {
+		v, ok, err := c.lookup()
+		if v != c.want || ok != c.wantok {
+			t.Errorf("%s: = %#v, %v, want %#v, %v", c.name, v, ok, c.want, c.wantok)
+		}
+		if fmt.Sprintf("%#v", err) != fmt.Sprintf("%#v", c.wanterr) {
+			t.Errorf("%s: err = %#v, want %#v", c.name, err, c.wanterr)
+		}
+	}
+}
+
+func TestConfigError(t *testing.T) {
+	_, _, err := LookupConfigIntBetween("int", 0, 10)
+	var cerr *ConfigError
+	if !errors.As(err, &cerr) {
+		t.Fatalf("errors.As(%v, *ConfigError) = false", err)
+	}
+	if cerr.Path != "int" {
+		t.Errorf("Path = %q, want %q", cerr.Path, "int")
+	}
+	if !errors.Is(err, ErrConfigRange) {
+		t.Errorf("errors.Is(%v, ErrConfigRange) = false", err)
+	}
+	if errors.Is(err, ErrConfigParse) {
+		t.Errorf("errors.Is(%v, ErrConfigParse) = true", err)
+	}
+	if want := "config int must contain integer <= 10"; err.Error() != want {
+		t.Errorf("Error() = %q, want %q", err.Error(), want)
+	}
+}
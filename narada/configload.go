@@ -0,0 +1,326 @@
+package narada
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	bytesType           = reflect.TypeOf([]byte(nil))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// LoadConfig fills fields of struct pointed by v using values from
+// config files. Fields are described using tags:
+//
+//	type Config struct {
+//		Port    int           `narada:"mysql/port" min:"1" max:"65535" default:"3306"`
+//		Host    string        `narada:"mysql/host"`
+//		Timeout time.Duration `narada:"timeout" min:"1s" default:"30s"`
+//		Ignore  []string      `narada:"mysql/dump/ignore"`
+//		Backup  BackupConfig  `narada:"backup"`
+//	}
+//
+// Tag "narada" contains config name (relative to "config/"). For nested
+// struct it contains config directory (or it may be omitted to use same
+// directory as parent struct, so nested struct without tag is loaded
+// too). Other fields without "narada" tag and fields with tag "-" are
+// ignored.
+//
+// Supported field types are: string, bool, all int, uint and float
+// types, time.Duration, types implementing encoding.TextUnmarshaler,
+// []byte (whole file contents) and slices of other supported types
+// (one non-empty line of file per element).
+//
+// Value from tag "default" is used if config file doesn't exist or
+// empty, if there is no "default" tag then field is left unchanged.
+// For slices "default" contains space-separated elements.
+// Tags "min" and "max" are supported for numbers and durations.
+//
+// All invalid config values are reported together in returned error
+// (see errors.Join), each of them is *ConfigError or error returned by
+// LookupConfig. Invalid struct definition results in returning
+// non-*ConfigError error immediately.
+func LoadConfig(v interface{}) error { return defaultProject.LoadConfig(v) }
+
+// LoadConfig works like package-level LoadConfig.
+func (p *Project) LoadConfig(v interface{}) error {
+	return (&configLoader{r: p.configReader}).load(v)
+}
+
+type configLoader struct {
+	r    configReader
+	errs []error
+}
+
+func (ld *configLoader) load(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return fmt.Errorf("narada.LoadConfig: require non-nil pointer to struct, got %T", v)
+	}
+	if err := ld.loadStruct(rv.Elem(), ""); err != nil {
+		return err
+	}
+	return errors.Join(ld.errs...)
+}
+
+func (ld *configLoader) loadStruct(v reflect.Value, dir string) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field, fv := t.Field(i), v.Field(i)
+		name, ok := field.Tag.Lookup("narada")
+		if name == "-" || !fv.CanSet() {
+			continue
+		}
+		if fv.Kind() == reflect.Struct && !isTextUnmarshaler(fv) {
+			subdir := dir
+			if name != "" {
+				subdir += name + "/"
+			}
+			if err := ld.loadStruct(fv, subdir); err != nil {
+				return err
+			}
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if err := ld.loadField(fv, dir+name, field.Tag); err != nil {
+			return fmt.Errorf("narada.LoadConfig: field %s.%s: %w", t, field.Name, err)
+		}
+	}
+	return nil
+}
+
+func (ld *configLoader) loadField(v reflect.Value, name string, tag reflect.StructTag) error {
+	buf, _, err := ld.r.LookupConfig(name)
+	if err != nil {
+		if errors.Is(err, ErrConfigName) {
+			return err
+		}
+		ld.errs = append(ld.errs, err)
+		return nil
+	}
+
+	path := ld.r.dir + name // Used in errors.
+	if v.Type() == bytesType {
+		if len(buf) == 0 {
+			if def, ok := tag.Lookup("default"); ok {
+				buf = []byte(def)
+			} else {
+				return nil
+			}
+		}
+		v.SetBytes(buf)
+		return nil
+	}
+
+	var values []string
+	if v.Kind() == reflect.Slice && !isTextUnmarshaler(v) {
+		for _, line := range strings.Split(string(buf), "\n") {
+			if strings.TrimSpace(line) != "" {
+				values = append(values, line)
+			}
+		}
+	} else {
+		line, err := firstLine(path, buf)
+		if err != nil {
+			ld.errs = append(ld.errs, err)
+			return nil
+		}
+		if strings.TrimSpace(line) != "" {
+			values = []string{line}
+		}
+	}
+	if len(values) == 0 {
+		def, ok := tag.Lookup("default")
+		if !ok {
+			return nil
+		}
+		values = []string{def}
+		if v.Kind() == reflect.Slice && !isTextUnmarshaler(v) {
+			values = strings.Fields(def)
+		}
+	}
+
+	if v.Kind() == reflect.Slice && !isTextUnmarshaler(v) {
+		slice := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, s := range values {
+			if err := ld.setValue(slice.Index(i), path, s, tag); err != nil {
+				return err
+			}
+		}
+		v.Set(slice)
+		return nil
+	}
+	return ld.setValue(v, path, values[0], tag)
+}
+
+// setValue returns error only for invalid struct definition, invalid
+// config values are added to ld.errs.
+func (ld *configLoader) setValue(v reflect.Value, path, s string, tag reflect.StructTag) error { // nolint:gocyclo
+	if isTextUnmarshaler(v) {
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigParse, Want: v.Type().String()})
+		}
+		return nil
+	}
+	if v.Kind() == reflect.String {
+		v.SetString(s)
+		return nil
+	}
+	s = strings.TrimSpace(s)
+	switch {
+	case v.Type() == durationType:
+		min, max, err := durationLimits(tag)
+		if err != nil {
+			return err
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigParse, Want: "duration"})
+		} else if d, err = checkDurationBetween(path, d, min, max); err != nil {
+			ld.errs = append(ld.errs, err)
+		} else {
+			v.SetInt(int64(d))
+		}
+	case v.Kind() >= reflect.Int && v.Kind() <= reflect.Int64:
+		min, max, err := intLimits(tag, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		i, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigParse, Want: "integer"})
+		} else if i < min {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigRange, Want: fmt.Sprintf("integer >= %d", min)})
+		} else if i > max {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigRange, Want: fmt.Sprintf("integer <= %d", max)})
+		} else {
+			v.SetInt(i)
+		}
+	case v.Kind() >= reflect.Uint && v.Kind() <= reflect.Uintptr:
+		min, max, err := uintLimits(tag, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		u, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigParse, Want: "unsigned integer"})
+		} else if u < min {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigRange, Want: fmt.Sprintf("unsigned integer >= %d", min)})
+		} else if u > max {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigRange, Want: fmt.Sprintf("unsigned integer <= %d", max)})
+		} else {
+			v.SetUint(u)
+		}
+	case v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64:
+		min, max, err := floatLimits(tag)
+		if err != nil {
+			return err
+		}
+		f, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigParse, Want: "number"})
+		} else if f < min {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigRange, Want: fmt.Sprintf("number >= %v", min)})
+		} else if f > max {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigRange, Want: fmt.Sprintf("number <= %v", max)})
+		} else {
+			v.SetFloat(f)
+		}
+	case v.Kind() == reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			ld.errs = append(ld.errs, &ConfigError{Path: path, Reason: ErrConfigParse, Want: "boolean"})
+		} else {
+			v.SetBool(b)
+		}
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+	return nil
+}
+
+func isTextUnmarshaler(v reflect.Value) bool {
+	return v.CanAddr() && v.Addr().Type().Implements(textUnmarshalerType)
+}
+
+// firstLine returns first line of config contents.
+func firstLine(path string, buf []byte) (string, error) {
+	if n := bytes.IndexByte(buf, byte('\n')); n >= 0 {
+		if len(bytes.TrimSpace(buf[n:])) != 0 {
+			return "", &ConfigError{Path: path, Reason: ErrConfigMultiLine}
+		}
+		buf = buf[:n]
+	}
+	return string(buf), nil
+}
+
+func durationLimits(tag reflect.StructTag) (min, max time.Duration, err error) {
+	min, max = -1<<63, 1<<63-1
+	if s, ok := tag.Lookup("min"); ok {
+		if min, err = time.ParseDuration(s); err != nil {
+			return 0, 0, fmt.Errorf("bad tag min: %w", err)
+		}
+	}
+	if s, ok := tag.Lookup("max"); ok {
+		if max, err = time.ParseDuration(s); err != nil {
+			return 0, 0, fmt.Errorf("bad tag max: %w", err)
+		}
+	}
+	return min, max, nil
+}
+
+func intLimits(tag reflect.StructTag, bits int) (min, max int64, err error) {
+	min, max = -1<<(bits-1), 1<<(bits-1)-1
+	if s, ok := tag.Lookup("min"); ok {
+		if min, err = strconv.ParseInt(s, 10, bits); err != nil {
+			return 0, 0, fmt.Errorf("bad tag min: %w", err)
+		}
+	}
+	if s, ok := tag.Lookup("max"); ok {
+		if max, err = strconv.ParseInt(s, 10, bits); err != nil {
+			return 0, 0, fmt.Errorf("bad tag max: %w", err)
+		}
+	}
+	return min, max, nil
+}
+
+func uintLimits(tag reflect.StructTag, bits int) (min, max uint64, err error) {
+	min, max = 0, math.MaxUint64>>uint(64-bits)
+	if s, ok := tag.Lookup("min"); ok {
+		if min, err = strconv.ParseUint(s, 10, bits); err != nil {
+			return 0, 0, fmt.Errorf("bad tag min: %w", err)
+		}
+	}
+	if s, ok := tag.Lookup("max"); ok {
+		if max, err = strconv.ParseUint(s, 10, bits); err != nil {
+			return 0, 0, fmt.Errorf("bad tag max: %w", err)
+		}
+	}
+	return min, max, nil
+}
+
+func floatLimits(tag reflect.StructTag) (min, max float64, err error) {
+	min, max = math.Inf(-1), math.Inf(1)
+	if s, ok := tag.Lookup("min"); ok {
+		if min, err = strconv.ParseFloat(s, 64); err != nil {
+			return 0, 0, fmt.Errorf("bad tag min: %w", err)
+		}
+	}
+	if s, ok := tag.Lookup("max"); ok {
+		if max, err = strconv.ParseFloat(s, 64); err != nil {
+			return 0, 0, fmt.Errorf("bad tag max: %w", err)
+		}
+	}
+	return min, max, nil
+}
@@ -0,0 +1,149 @@
+package narada
+
+import (
+	"errors"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type testMySQLConfig struct {
+	Host  string `narada:"host" default:"localhost"`
+	Port  int    `narada:"port" min:"1" max:"65535" default:"3306"`
+	Login string `narada:"login"`
+}
+
+type testConfig struct {
+	MySQL  testMySQLConfig `narada:"mysql"`
+	Inline struct {
+		Int int8 `narada:"int"`
+	}
+	Timeout  time.Duration `narada:"timeout" min:"1s" default:"30s"`
+	Uint     uint16        `narada:"uint" max:"100"`
+	Float    float64       `narada:"float"`
+	Enabled  bool          `narada:"enabled"`
+	IP       net.IP        `narada:"ip"`
+	Ignore   []string      `narada:"ignore"`
+	Ports    []int         `narada:"ports" default:"80 443"`
+	Raw      []byte        `narada:"dir/file"`
+	Kept     string        `narada:"nosuch"`
+	Skipped  string        `narada:"-"`
+	NoTag    string
+	unexport string `narada:"file"` // nolint:unused,structcheck
+}
+
+func TestLoadConfig(t *testing.T) {
+	origOpen := defaultProject.open
+	defer func() { defaultProject.open = origOpen }()
+	FakeConfig(map[string]string{
+		"mysql/host":  "",
+		"mysql/port":  "3307\n",
+		"mysql/login": "user\n",
+		"timeout":     "",
+		"uint":        "42",
+		"enabled":     "true",
+		"ip":          "127.0.0.1",
+		"ignore":      "a\n\nb c\n",
+	})
+	cfg := testConfig{Kept: "kept", Skipped: "skip", NoTag: "notag"}
+	err := LoadConfig(&cfg)
+	if err != nil {
+		t.Fatalf("LoadConfig(), err = %v", err)
+	}
+	want := testConfig{
+		MySQL: testMySQLConfig{Host: "localhost", Port: 3307, Login: "user"},
+		Inline: struct {
+			Int int8 `narada:"int"`
+		}{42},
+		Timeout: 30 * time.Second,
+		Uint:    42,
+		Float:   42.777,
+		Enabled: true,
+		IP:      net.ParseIP("127.0.0.1"),
+		Ignore:  []string{"a", "b c"},
+		Ports:   []int{80, 443},
+		Raw:     []byte("Real2\n"),
+		Kept:    "kept",
+		Skipped: "skip",
+		NoTag:   "notag",
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadConfig() =\n%#v\nwant\n%#v", cfg, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	origOpen := defaultProject.open
+	defer func() { defaultProject.open = origOpen }()
+	FakeConfig(map[string]string{
+		"mysql/port":  "0",
+		"mysql/login": "a\nb",
+		"int":         "128",
+		"timeout":     "1ms",
+		"uint":        "-1",
+		"float":       "x",
+		"enabled":     "yes",
+		"ip":          "localhost",
+		"ports":       "80\nhttp\n",
+	})
+	var cfg testConfig
+	err := LoadConfig(&cfg)
+	wanterrs := []error{
+		&ConfigError{Path: "mysql/port", Reason: ErrConfigRange, Want: "integer >= 1"},
+		&ConfigError{Path: "mysql/login", Reason: ErrConfigMultiLine},
+		&ConfigError{Path: "int", Reason: ErrConfigParse, Want: "integer"},
+		&ConfigError{Path: "timeout", Reason: ErrConfigRange, Want: "duration >= 1s"},
+		&ConfigError{Path: "uint", Reason: ErrConfigParse, Want: "unsigned integer"},
+		&ConfigError{Path: "float", Reason: ErrConfigParse, Want: "number"},
+		&ConfigError{Path: "enabled", Reason: ErrConfigParse, Want: "boolean"},
+		&ConfigError{Path: "ip", Reason: ErrConfigParse, Want: "net.IP"},
+		&ConfigError{Path: "ports", Reason: ErrConfigParse, Want: "integer"},
+	}
+	var errs []error
+	if err != nil {
+		errs = err.(interface{ Unwrap() []error }).Unwrap()
+	}
+	if !reflect.DeepEqual(errs, wanterrs) {
+		t.Errorf("LoadConfig(), err =\n%v\nwant\n%v", err, errors.Join(wanterrs...))
+	}
+	if !errors.Is(err, ErrConfigRange) || !errors.Is(err, ErrConfigMultiLine) {
+		t.Errorf("errors.Is(err, ErrConfigRange/ErrConfigMultiLine) = false")
+	}
+}
+
+func TestLoadConfigBad(t *testing.T) {
+	var i int
+	cases := []struct {
+		v       interface{}
+		wanterr string
+	}{
+		{nil, "require non-nil pointer to struct, got <nil>"},
+		{i, "require non-nil pointer to struct, got int"},
+		{&i, "require non-nil pointer to struct, got *int"},
+		{(*testConfig)(nil), "require non-nil pointer to struct, got *narada.testConfig"},
+		{&struct {
+			M map[string]int `narada:"file"`
+		}{}, "unsupported type map[string]int"},
+		{&struct {
+			I int `narada:"int" min:"x"`
+		}{}, "bad tag min"},
+		{&struct {
+			D time.Duration `narada:"duration" max:"1"`
+		}{}, "bad tag max"},
+		{&struct {
+			S string `narada:"../file"`
+		}{}, "invalid config name: ../file"},
+	}
+	for _, c := range cases {
+		err := LoadConfig(c.v)
+		if err == nil || !strings.Contains(err.Error(), c.wanterr) {
+			t.Errorf("LoadConfig(%T), err = %v, want %q", c.v, err, c.wanterr)
+		}
+		var cerr *ConfigError
+		if errors.As(err, &cerr) && cerr.Reason != ErrConfigName {
+			t.Errorf("LoadConfig(%T), err = %#v, want not *ConfigError", c.v, err)
+		}
+	}
+}
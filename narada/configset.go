@@ -0,0 +1,52 @@
+package narada
+
+import (
+	"context"
+	"strings"
+
+	"github.com/powerman/narada-go/narada/internal/atomicfile"
+)
+
+// SetConfig atomically replaces contents of file "config/"+path with data
+// (concurrent readers will see either old or new contents).
+// Missing directories are created. Permissions of existing file are
+// preserved, new file gets 0644.
+// Returns *ConfigError on invalid config name.
+func SetConfig(path string, data []byte) error { return defaultProject.SetConfig(path, data) }
+
+// SetConfig works like package-level SetConfig.
+func (p *Project) SetConfig(path string, data []byte) error {
+	return p.SetConfigContext(context.Background(), path, data)
+}
+
+// SetConfigContext works like SetConfig but gets shared lock using
+// SharedLockContext(ctx), so it may be called while ctx carries lock (see
+// WithSharedLock and WithExclusiveLock).
+func SetConfigContext(ctx context.Context, path string, data []byte) error {
+	return defaultProject.SetConfigContext(ctx, path, data)
+}
+
+// SetConfigContext works like package-level SetConfigContext.
+func (p *Project) SetConfigContext(ctx context.Context, path string, data []byte) error {
+	if invalidName.MatchString(path) || !validName.MatchString(path) {
+		return &ConfigError{Path: path, Reason: ErrConfigName}
+	}
+	lock, err := p.SharedLockContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.UnLock()
+	return atomicfile.WriteFile(p.Path(configDir+path), data, 0644)
+}
+
+// SetConfigLine works like SetConfig but writes line with "\n" appended.
+// Returns *ConfigError if line contains "\n".
+func SetConfigLine(path, line string) error { return defaultProject.SetConfigLine(path, line) }
+
+// SetConfigLine works like package-level SetConfigLine.
+func (p *Project) SetConfigLine(path, line string) error {
+	if strings.Contains(line, "\n") {
+		return &ConfigError{Path: path, Reason: ErrConfigMultiLine}
+	}
+	return p.SetConfig(path, []byte(line+"\n"))
+}
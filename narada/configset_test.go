@@ -0,0 +1,81 @@
+package narada
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestSetConfig(t *testing.T) {
+	defer os.RemoveAll("config/set")
+	cases := []struct {
+		path     string
+		data     []byte
+		wantperm os.FileMode
+	}{
+		{"set/new", []byte("new"), 0644},
+		{"set/new", []byte{}, 0644},
+		{"set/deep/dir/file", []byte("a\nb\n"), 0644},
+		{"set/deep/dir/file", nil, 0644},
+	}
+	for _, c := range cases {
+		if err := SetConfig(c.path, c.data); err != nil {
+			t.Errorf("SetConfig(%q), err = %v", c.path, err)
+			continue
+		}
+		buf, err := GetConfig(c.path)
+		if err != nil || !bytes.Equal(buf, c.data) {
+			t.Errorf("GetConfig(%q) = %q, %v, want %q", c.path, buf, err, c.data)
+		}
+		fi, err := os.Stat(configDir + c.path)
+		if err != nil || fi.Mode().Perm() != c.wantperm {
+			t.Errorf("os.Stat(%q) = %v, %v, want mode %v", c.path, fi.Mode(), err, c.wantperm)
+		}
+	}
+
+	if err := os.Chmod("config/set/new", 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := SetConfig("set/new", []byte("perm")); err != nil {
+		t.Errorf("SetConfig(), err = %v", err)
+	}
+	if fi, err := os.Stat("config/set/new"); err != nil || fi.Mode().Perm() != 0600 {
+		t.Errorf("os.Stat() = %v, %v, want mode 0600", fi.Mode(), err)
+	}
+
+	fis, err := ioutil.ReadDir("config/set")
+	if err != nil || len(fis) != 2 {
+		t.Errorf("ReadDir() = %v, %v, want no temporary files", fis, err)
+	}
+}
+
+func TestSetConfigLine(t *testing.T) {
+	defer os.RemoveAll("config/set")
+	if err := SetConfigLine("set/line", "value"); err != nil {
+		t.Errorf("SetConfigLine(), err = %v", err)
+	}
+	if line := GetConfigLine("set/line"); line != "value" {
+		t.Errorf("GetConfigLine() = %q, want %q", line, "value")
+	}
+
+	cases := []struct {
+		path    string
+		line    string
+		wanterr error
+	}{
+		{"set/line", "a\nb", &ConfigError{Path: "set/line", Reason: ErrConfigMultiLine}},
+		{"set/../line", "a", &ConfigError{Path: "set/../line", Reason: ErrConfigName}},
+		{"", "a", &ConfigError{Path: "", Reason: ErrConfigName}},
+	}
+	for _, c := range cases {
+		err := SetConfigLine(c.path, c.line)
+		if fmt.Sprintf("%#v", err) != fmt.Sprintf("%#v", c.wanterr) {
+			t.Errorf("SetConfigLine(%q, %q), err = %#v, want %#v", c.path, c.line, err, c.wanterr)
+		}
+	}
+	if line := GetConfigLine("set/line"); line != "value" {
+		t.Errorf("GetConfigLine() = %q, want %q", line, "value")
+	}
+}
@@ -0,0 +1,119 @@
+package narada
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ConfigSnapshot contains contents of config files read at one moment
+// (under one SharedLock), so values read from it are consistent with
+// each other.
+//
+// It provides same getters as package-level functions (GetConfig,
+// LookupConfigInt, LoadConfig, etc.), but config names are relative to
+// snapshot directory. Config names in returned errors are full.
+type ConfigSnapshot struct {
+	configReader
+	configs map[string]snapshotValue
+}
+
+type snapshotValue struct {
+	buf []byte
+	err error
+}
+
+// ReadConfigSnapshot reads all files in "config/"+dir and it's
+// subdirectories. Use empty dir to read all config files.
+// If dir not exists snapshot will be empty.
+func ReadConfigSnapshot(dir string) (*ConfigSnapshot, error) {
+	return defaultProject.ReadConfigSnapshot(dir)
+}
+
+// ReadConfigSnapshot works like package-level ReadConfigSnapshot.
+func (p *Project) ReadConfigSnapshot(dir string) (*ConfigSnapshot, error) {
+	return p.ReadConfigSnapshotContext(context.Background(), dir)
+}
+
+// ReadConfigSnapshotContext works like ReadConfigSnapshot but gets shared
+// lock using SharedLockContext(ctx), so it may be called while ctx
+// carries lock (see WithSharedLock and WithExclusiveLock).
+func ReadConfigSnapshotContext(ctx context.Context, dir string) (*ConfigSnapshot, error) {
+	return defaultProject.ReadConfigSnapshotContext(ctx, dir)
+}
+
+// ReadConfigSnapshotContext works like package-level
+// ReadConfigSnapshotContext.
+func (p *Project) ReadConfigSnapshotContext(ctx context.Context, dir string) (*ConfigSnapshot, error) {
+	dir = strings.TrimSuffix(dir, "/")
+	if dir != "" {
+		if invalidName.MatchString(dir) || !validName.MatchString(dir) {
+			return nil, &ConfigError{Path: dir, Reason: ErrConfigName}
+		}
+		dir += "/"
+	}
+	s := &ConfigSnapshot{configs: make(map[string]snapshotValue)}
+	s.configReader = configReader{dir: dir, lookup: s.lookup}
+
+	lock, err := p.SharedLockContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.UnLock()
+	root := p.Path(configDir+dir) + "/"
+	err = filepath.Walk(root, func(name string, fi os.FileInfo, err error) error {
+		if err != nil {
+			if name == root && os.IsNotExist(err) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		buf, errRead := ioutil.ReadFile(name)
+		if buf == nil {
+			buf = []byte{}
+		}
+		s.configs[filepath.ToSlash(name[len(root):])] = snapshotValue{buf: buf, err: errRead}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func (s *ConfigSnapshot) lookup(path string) ([]byte, bool, error) {
+	v, ok := s.configs[path]
+	if !ok {
+		return nil, false, nil
+	}
+	if v.err != nil {
+		return nil, false, v.err
+	}
+	return v.buf, true, nil
+}
+
+// Dir returns snapshot directory (relative to "config/").
+func (s *ConfigSnapshot) Dir() string {
+	return s.dir
+}
+
+// Names returns sorted names of all configs in snapshot.
+func (s *ConfigSnapshot) Names() []string {
+	names := make([]string, 0, len(s.configs))
+	for name := range s.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LoadConfig works like package-level LoadConfig.
+func (s *ConfigSnapshot) LoadConfig(v interface{}) error {
+	return (&configLoader{r: s.configReader}).load(v)
+}
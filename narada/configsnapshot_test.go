@@ -0,0 +1,96 @@
+package narada
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReadConfigSnapshot(t *testing.T) {
+	snap, err := ReadConfigSnapshot("")
+	if err != nil {
+		t.Fatalf("ReadConfigSnapshot(), err = %v", err)
+	}
+	if snap.Dir() != "" {
+		t.Errorf("Dir() = %q, want %q", snap.Dir(), "")
+	}
+	if i := snap.GetConfigInt("int"); i != 42 {
+		t.Errorf("GetConfigInt() = %v, want 42", i)
+	}
+	if line := snap.GetConfigLine("dir/file"); line != "Real2" {
+		t.Errorf("GetConfigLine() = %q, want %q", line, "Real2")
+	}
+	_, _, err = snap.LookupConfig("unreadable")
+	wanterr := &os.PathError{Op: "open", Path: Path("config/unreadable"), Err: syscall.EACCES}
+	if fmt.Sprintf("%#v", err) != fmt.Sprintf("%#v", wanterr) {
+		t.Errorf("LookupConfig(), err = %#v, want %#v", err, wanterr)
+	}
+
+	snap, err = ReadConfigSnapshot("log/")
+	if err != nil {
+		t.Fatalf("ReadConfigSnapshot(), err = %v", err)
+	}
+	if err = ioutil.WriteFile("config/log/level", []byte("ERR\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	defer ioutil.WriteFile("config/log/level", []byte("INFO\n"), 0644)
+	if snap.Dir() != "log/" {
+		t.Errorf("Dir() = %q, want %q", snap.Dir(), "log/")
+	}
+	wantNames := []string{"level", "output", "type"}
+	if names := snap.Names(); !reflect.DeepEqual(names, wantNames) {
+		t.Errorf("Names() = %q, want %q", names, wantNames)
+	}
+	cases := []struct {
+		path    string
+		want    string
+		wantok  bool
+		wanterr error
+	}{
+		{"level", "INFO", true, nil},
+		{"type", "syslog", true, nil},
+		{"nosuch", "", false, nil},
+		{"file", "", false, nil},
+		{"../file", "", false, &ConfigError{Path: "log/../file", Reason: ErrConfigName}},
+	}
+	for _, c := range cases {
+		line, ok, err := snap.LookupConfigLine(c.path)
+		if line != c.want || ok != c.wantok {
+			t.Errorf("LookupConfigLine(%q) = %q, %v, want %q, %v", c.path, line, ok, c.want, c.wantok)
+		}
+		if fmt.Sprintf("%#v", err) != fmt.Sprintf("%#v", c.wanterr) {
+			t.Errorf("LookupConfigLine(%q), err = %#v, want %#v", c.path, err, c.wanterr)
+		}
+	}
+	_, _, err = snap.LookupConfigDuration("level")
+	wanterr2 := &ConfigError{Path: "log/level", Reason: ErrConfigParse, Want: "duration"}
+	if fmt.Sprintf("%#v", err) != fmt.Sprintf("%#v", wanterr2) {
+		t.Errorf("LookupConfigDuration(), err = %#v, want %#v", err, wanterr2)
+	}
+
+	var cfg struct {
+		Level   string        `narada:"level"`
+		Timeout time.Duration `narada:"type"`
+	}
+	err = snap.LoadConfig(&cfg)
+	wanterr2 = &ConfigError{Path: "log/type", Reason: ErrConfigParse, Want: "duration"}
+	if cfg.Level != "INFO" || err == nil || err.Error() != wanterr2.Error() {
+		t.Errorf("LoadConfig() = %#v, %v, want level INFO and %v", cfg, err, wanterr2)
+	}
+}
+
+func TestReadConfigSnapshotBad(t *testing.T) {
+	snap, err := ReadConfigSnapshot("nosuch")
+	if err != nil || len(snap.Names()) != 0 {
+		t.Errorf("ReadConfigSnapshot(nosuch) = %v, %v, want empty", snap.Names(), err)
+	}
+	_, err = ReadConfigSnapshot("../config")
+	wanterr := &ConfigError{Path: "../config", Reason: ErrConfigName}
+	if fmt.Sprintf("%#v", err) != fmt.Sprintf("%#v", wanterr) {
+		t.Errorf("ReadConfigSnapshot(), err = %#v, want %#v", err, wanterr)
+	}
+}
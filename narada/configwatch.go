@@ -0,0 +1,272 @@
+package narada
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// configWatchDelay is used to merge bursts of changes in config/.
+const configWatchDelay = 100 * time.Millisecond
+
+// configWatchMaxDelay limits delay of reporting changes in case of
+// endless burst (like a file in config/ which is written continuously).
+const configWatchMaxDelay = 10 * configWatchDelay
+
+const configWatchMask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE |
+	unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_ONLYDIR
+
+// ConfigChange describes changed config file.
+type ConfigChange struct {
+	Path string // Config name (without "config/" prefix).
+	Old  []byte // Previous contents, nil if config didn't exist.
+	New  []byte // Current contents, nil if config was removed.
+}
+
+// WatchConfig starts watching for changes in all files in config/ tree
+// until ctx is done. Bursts of changes are merged (but endless burst is
+// still reported about once a second), changed files are re-read under
+// SharedLock and then onChange is called for each of them
+// (in order of config name). Errors happened while watching are
+// reported using onError (if not nil).
+//
+// Files are read directly, FakeConfig has no effect on WatchConfig.
+//
+// It returns error only if it failed to start watching.
+func WatchConfig(ctx context.Context, onChange func(ConfigChange), onError func(error)) error {
+	return defaultProject.WatchConfig(ctx, onChange, onError)
+}
+
+// WatchConfig works like package-level WatchConfig.
+func (p *Project) WatchConfig(ctx context.Context, onChange func(ConfigChange), onError func(error)) error {
+	if onError == nil {
+		onError = func(error) {}
+	}
+	cw := &configWatcher{
+		p:        p,
+		dirs:     make(map[int]string),
+		values:   make(map[string][]byte),
+		dirty:    make(map[string]bool),
+		onChange: onChange,
+		onError:  onError,
+	}
+	var err error
+	if cw.w, err = newWatcher(); err != nil {
+		return err
+	}
+	if err = cw.init(ctx); err != nil {
+		cw.w.Close()
+		return err
+	}
+	go cw.run(ctx)
+	return nil
+}
+
+type configWatcher struct {
+	p        *Project
+	w        *watcher
+	dirs     map[int]string    // Watch descriptor => config dir name ("" or "dir/").
+	values   map[string][]byte // Config name => contents.
+	dirty    map[string]bool   // Config names which should be re-read.
+	newDirs  []string
+	onChange func(ConfigChange)
+	onError  func(error)
+}
+
+func (cw *configWatcher) init(ctx context.Context) error {
+	lock, err := cw.p.SharedLockContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.UnLock()
+	if err = cw.addDir(""); err != nil {
+		return err
+	}
+	for path := range cw.dirty {
+		buf, err := cw.readConfigFile(path)
+		if err != nil {
+			cw.onError(err)
+		} else if buf != nil {
+			cw.values[path] = buf
+		}
+	}
+	cw.dirty = make(map[string]bool)
+	return nil
+}
+
+// addDir starts watching config dir and all its subdirectories and
+// marks all files in them as dirty.
+func (cw *configWatcher) addDir(dir string) error {
+	wd, err := cw.w.add(cw.p.Path(configDir+dir), configWatchMask)
+	if err != nil {
+		return err
+	}
+	cw.dirs[wd] = dir
+	fis, err := ioutil.ReadDir(cw.p.Path(configDir + dir))
+	if err != nil {
+		return err
+	}
+	for _, fi := range fis {
+		name := dir + fi.Name()
+		if fi.IsDir() {
+			if err = cw.addDir(name + "/"); err != nil {
+				return err
+			}
+		} else {
+			cw.dirty[name] = true
+		}
+	}
+	return nil
+}
+
+func (cw *configWatcher) run(ctx context.Context) {
+	defer cw.w.Close()
+	eventc := make(chan []watchEvent)
+	go func() {
+		defer close(eventc)
+		defer cw.w.closeOnDone(ctx)()
+		for {
+			events, err := cw.w.read()
+			if err != nil {
+				if ctx.Err() == nil {
+					cw.onError(err)
+				}
+				return
+			}
+			eventc <- events
+		}
+	}()
+
+	timer := time.NewTimer(configWatchDelay)
+	timer.Stop()
+	var first time.Time // Of not flushed events, zero if none.
+	for {
+		select {
+		case events, ok := <-eventc:
+			if !ok {
+				return
+			}
+			for _, ev := range events {
+				cw.handle(ev)
+			}
+			if first.IsZero() {
+				first = time.Now()
+			}
+			delay := configWatchDelay
+			if left := configWatchMaxDelay - time.Since(first); left < delay {
+				delay = left
+			}
+			timer.Reset(delay)
+		case <-timer.C:
+			if cw.flush(ctx) {
+				first = time.Time{}
+			} else {
+				timer.Reset(configWatchDelay) // Retry, there may be no more events.
+			}
+		}
+	}
+}
+
+func (cw *configWatcher) handle(ev watchEvent) {
+	if ev.mask&unix.IN_Q_OVERFLOW != 0 {
+		for path := range cw.values {
+			cw.dirty[path] = true
+		}
+		cw.newDirs = append(cw.newDirs, "")
+		return
+	}
+	dir, ok := cw.dirs[ev.wd]
+	if !ok {
+		return
+	}
+	if ev.mask&unix.IN_IGNORED != 0 {
+		delete(cw.dirs, ev.wd)
+		return
+	}
+	name := dir + ev.name
+	switch {
+	case ev.mask&unix.IN_ISDIR == 0:
+		cw.dirty[name] = true
+	case ev.mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0:
+		cw.newDirs = append(cw.newDirs, name+"/")
+	case ev.mask&(unix.IN_DELETE|unix.IN_MOVED_FROM) != 0:
+		for path := range cw.values {
+			if strings.HasPrefix(path, name+"/") {
+				cw.dirty[path] = true
+			}
+		}
+	}
+}
+
+// flush returns false if it failed to get lock (dirty configs are kept
+// in this case).
+func (cw *configWatcher) flush(ctx context.Context) bool {
+	lock, err := cw.p.SharedLockContext(ctx)
+	if err != nil {
+		if ctx.Err() == nil {
+			cw.onError(err)
+		}
+		return false
+	}
+	for _, dir := range cw.newDirs {
+		if err = cw.addDir(dir); err != nil && !os.IsNotExist(err) {
+			cw.onError(err)
+		}
+	}
+	cw.newDirs = nil
+	var changes []ConfigChange
+	for path := range cw.dirty {
+		buf, err := cw.readConfigFile(path)
+		if err != nil {
+			cw.onError(err)
+			continue
+		}
+		old := cw.values[path]
+		if (old == nil) == (buf == nil) && bytes.Equal(old, buf) {
+			continue
+		}
+		changes = append(changes, ConfigChange{Path: path, Old: old, New: buf})
+		if buf == nil {
+			delete(cw.values, path)
+		} else {
+			cw.values[path] = buf
+		}
+	}
+	cw.dirty = make(map[string]bool)
+	if err = lock.UnLock(); err != nil {
+		cw.onError(err)
+	}
+
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Path < changes[j].Path })
+	for _, change := range changes {
+		cw.onChange(change)
+	}
+	return true
+}
+
+// readConfigFile returns nil without error if config not exists or is a
+// directory.
+func (cw *configWatcher) readConfigFile(path string) ([]byte, error) {
+	buf, err := ioutil.ReadFile(cw.p.Path(configDir + path))
+	if os.IsNotExist(err) || isDirErr(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if buf == nil {
+		buf = []byte{}
+	}
+	return buf, nil
+}
+
+func isDirErr(err error) bool {
+	perr, ok := err.(*os.PathError)
+	return ok && perr.Err == unix.EISDIR
+}
@@ -0,0 +1,191 @@
+package narada
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestWatchConfig(t *testing.T) {
+	if err := os.Mkdir("config/watch", 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll("config/watch")
+	if err := ioutil.WriteFile("config/watch/a", []byte("1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan ConfigChange, 16)
+	errc := make(chan error, 16)
+	err := WatchConfig(ctx, func(c ConfigChange) { changes <- c }, func(err error) { errc <- err })
+	if err != nil {
+		t.Fatalf("WatchConfig(), err = %v", err)
+	}
+	select {
+	case <-errc: // config/unreadable
+	default:
+	}
+
+	check := func(name string, want []ConfigChange) {
+		t.Helper()
+		var got []ConfigChange
+		timeout := time.After(time.Second)
+	WAIT:
+		for len(got) < len(want) {
+			select {
+			case c := <-changes:
+				got = append(got, c)
+			case err := <-errc:
+				t.Errorf("%s: onError(%v)", name, err)
+			case <-timeout:
+				break WAIT
+			}
+		}
+		select {
+		case c := <-changes:
+			got = append(got, c)
+		case <-time.After(2 * configWatchDelay):
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: changes =\n%q\nwant\n%q", name, got, want)
+		}
+	}
+
+	if err = ioutil.WriteFile("config/watch/a", []byte("2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile("config/watch/a", []byte("3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile("config/watch/b", nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	check("modify", []ConfigChange{
+		{Path: "watch/a", Old: []byte("1"), New: []byte("3")},
+		{Path: "watch/b", Old: nil, New: []byte{}},
+	})
+
+	if err = ioutil.WriteFile("config/watch/a", []byte("3"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check("same", nil)
+
+	if err = os.MkdirAll("config/watch/sub/dir", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile("config/watch/sub/dir/c", []byte("c"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check("mkdir", []ConfigChange{
+		{Path: "watch/sub/dir/c", Old: nil, New: []byte("c")},
+	})
+	if err = ioutil.WriteFile("config/watch/sub/dir/c", []byte("C"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check("new dir watched", []ConfigChange{
+		{Path: "watch/sub/dir/c", Old: []byte("c"), New: []byte("C")},
+	})
+
+	if err = os.Rename("config/watch/sub", "config/watch/sub2"); err != nil {
+		t.Fatal(err)
+	}
+	check("rename dir", []ConfigChange{
+		{Path: "watch/sub/dir/c", Old: []byte("C"), New: nil},
+		{Path: "watch/sub2/dir/c", Old: nil, New: []byte("C")},
+	})
+
+	if err = os.Remove("config/watch/b"); err != nil {
+		t.Fatal(err)
+	}
+	check("remove", []ConfigChange{
+		{Path: "watch/b", Old: []byte{}, New: nil},
+	})
+
+	cancel()
+	time.Sleep(configWatchDelay)
+	if err = ioutil.WriteFile("config/watch/a", []byte("4"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	check("cancelled", nil)
+}
+
+func TestWatchConfigEndlessBurst(t *testing.T) {
+	p := newTestProject(t, "1.0.0")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan ConfigChange, 64)
+	err := p.WatchConfig(ctx, func(c ConfigChange) { changes <- c }, func(err error) { t.Error(err) })
+	if err != nil {
+		t.Fatalf("WatchConfig(), err = %v", err)
+	}
+
+	timeout := time.After(2 * configWatchMaxDelay)
+	for i := 0; ; i++ {
+		if err = ioutil.WriteFile(p.Path("config/burst"), []byte(strconv.Itoa(i)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-changes:
+			return
+		case <-timeout:
+			t.Fatal("no changes reported while config is written continuously")
+		case <-time.After(configWatchDelay / 4):
+		}
+	}
+}
+
+func TestWatchConfigLockError(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root ignores file permissions")
+	}
+	p := newTestProject(t, "1.0.0")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changes := make(chan ConfigChange, 16)
+	errc := make(chan error, 16)
+	err := p.WatchConfig(ctx, func(c ConfigChange) { changes <- c }, func(err error) { errc <- err })
+	if err != nil {
+		t.Fatalf("WatchConfig(), err = %v", err)
+	}
+
+	if err = os.Chmod(p.Path(lockfile), 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(p.Path("config/name"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-errc:
+		if !os.IsPermission(err) {
+			t.Errorf("onError(%v), want permission error", err)
+		}
+	case c := <-changes:
+		t.Fatalf("onChange(%q) without lock", c)
+	case <-time.After(time.Second):
+		t.Fatal("no lock error")
+	}
+
+	// Flush must be retried without new events.
+	if err = os.Chmod(p.Path(lockfile), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		select {
+		case <-errc:
+			continue
+		case c := <-changes:
+			if want := (ConfigChange{Path: "name", Old: []byte("1.0.0\n"), New: []byte("changed")}); !reflect.DeepEqual(c, want) {
+				t.Errorf("onChange(%q), want %q", c, want)
+			}
+		case <-time.After(time.Second):
+			t.Errorf("changes are not reported after lock error")
+		}
+		break
+	}
+}
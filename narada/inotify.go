@@ -0,0 +1,78 @@
+package narada
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// watcher is a minimal inotify wrapper.
+type watcher struct {
+	f   *os.File
+	buf [unix.SizeofInotifyEvent * 4096]byte
+}
+
+type watchEvent struct {
+	wd   int
+	mask uint32
+	name string
+}
+
+func newWatcher() (*watcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, os.NewSyscallError("inotify_init1", err)
+	}
+	return &watcher{f: os.NewFile(uintptr(fd), "inotify")}, nil
+}
+
+func (w *watcher) add(path string, mask uint32) (int, error) {
+	wd, err := unix.InotifyAddWatch(int(w.f.Fd()), path, mask)
+	if err != nil {
+		return 0, &os.PathError{Op: "inotify_add_watch", Path: path, Err: err}
+	}
+	return wd, nil
+}
+
+// read blocks until some events will be available or watcher will be
+// closed.
+func (w *watcher) read() ([]watchEvent, error) {
+	n, err := w.f.Read(w.buf[:])
+	if err != nil {
+		return nil, err
+	}
+	var events []watchEvent
+	for off := 0; off+unix.SizeofInotifyEvent <= n; {
+		raw := (*unix.InotifyEvent)(unsafe.Pointer(&w.buf[off]))
+		off += unix.SizeofInotifyEvent
+		name := w.buf[off : off+int(raw.Len)]
+		off += int(raw.Len)
+		events = append(events, watchEvent{
+			wd:   int(raw.Wd),
+			mask: raw.Mask,
+			name: string(bytes.TrimRight(name, "\x00")),
+		})
+	}
+	return events, nil
+}
+
+// closeOnDone will interrupt blocked read() when ctx is done.
+// Returned func must be called to free resources.
+func (w *watcher) closeOnDone(ctx context.Context) (stop func()) {
+	done := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			w.Close()
+		case <-done:
+		}
+	}()
+	return func() { close(done) }
+}
+
+func (w *watcher) Close() error {
+	return w.f.Close()
+}
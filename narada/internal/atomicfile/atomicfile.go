@@ -0,0 +1,78 @@
+// Package atomicfile replaces files atomically (concurrent readers will
+// see either old or new contents) for narada and its subpackages.
+package atomicfile
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// WriteFile works like Write with data as file contents.
+func WriteFile(name string, data []byte, perm os.FileMode) error {
+	return Write(name, perm, func(w io.Writer) error {
+		_, err := io.Copy(w, bytes.NewReader(data))
+		return err
+	})
+}
+
+// Write calls write to fill temporary file in same directory and then
+// renames it to name. It creates missing directories and preserves
+// permissions of existing file (or uses perm for new file).
+// File and directory are synced to make replacement durable.
+// If write returns error then file name is left unchanged.
+func Write(name string, perm os.FileMode, write func(io.Writer) error) (err error) {
+	dir := filepath.Dir(name)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	switch fi, errStat := os.Stat(name); {
+	case errStat == nil:
+		perm = fi.Mode().Perm()
+	case !os.IsNotExist(errStat):
+		return errStat
+	}
+
+	f, err := ioutil.TempFile(dir, "."+filepath.Base(name)+".*")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(f.Name())
+		}
+	}()
+	w := bufio.NewWriter(f)
+	if err = write(w); err != nil {
+		return err
+	}
+	if err = w.Flush(); err != nil {
+		return err
+	}
+	if err = f.Chmod(perm); err != nil {
+		return err
+	}
+	if err = f.Sync(); err != nil {
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(f.Name(), name); err != nil {
+		return err
+	}
+	return syncDir(dir)
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,55 @@
+package atomicfile
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, "sub", "file")
+
+	if err := WriteFile(name, []byte("one\n"), 0600); err != nil {
+		t.Fatalf("WriteFile(), err = %v", err)
+	}
+	if err := os.Chmod(name, 0640); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteFile(name, []byte("two\n"), 0600); err != nil {
+		t.Fatalf("WriteFile(), err = %v", err)
+	}
+	errWrite := errors.New("write failed")
+	err := Write(name, 0600, func(w io.Writer) error {
+		_, _ = io.WriteString(w, "three\n")
+		return errWrite
+	})
+	if err != errWrite {
+		t.Errorf("Write(), err = %v, want %v", err, errWrite)
+	}
+
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if perm := fi.Mode().Perm(); perm != 0640 {
+		t.Errorf("perm = %v, want %v", perm, os.FileMode(0640))
+	}
+	data, err := ioutil.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "two\n" {
+		t.Errorf("contents = %q, want %q", data, "two\n")
+	}
+	files, err := ioutil.ReadDir(filepath.Dir(name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Errorf("dir contains %d files, want 1 (temporary files left)", len(files))
+	}
+}
@@ -0,0 +1,118 @@
+package stagingdir
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CopyTree copies contents of directory src into directory dst,
+// overwriting existing files.
+func CopyTree(dst, src string) error {
+	return filepath.Walk(src, func(path string, fi os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		name := filepath.Join(dst, path[len(src):])
+		switch {
+		case fi.IsDir():
+			return os.MkdirAll(name, fi.Mode().Perm()|0700)
+		case fi.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			if err = os.Remove(name); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+			return os.Symlink(link, name)
+		case fi.Mode().IsRegular():
+			return copyFile(name, path, fi.Mode().Perm())
+		}
+		return nil
+	})
+}
+
+func copyFile(dst, src string, perm os.FileMode) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if errClose := out.Close(); err == nil {
+		err = errClose
+	}
+	if err == nil {
+		err = os.Chmod(dst, perm) // Existing file keeps own permissions.
+	}
+	return err
+}
+
+// ExtractTxtar writes files from txtar archive (see
+// golang.org/x/tools/txtar) into directory dst, overwriting existing
+// files and creating missing directories. Archive comment is ignored.
+func ExtractTxtar(dst string, data []byte) error {
+	files, err := parseTxtar(data)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		name := filepath.Join(dst, file.name)
+		err = os.MkdirAll(filepath.Dir(name), 0777)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(name, file.data, 0666)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type txtarFile struct {
+	name string
+	data []byte
+}
+
+func parseTxtar(data []byte) ([]txtarFile, error) {
+	var files []txtarFile
+	var cur *txtarFile
+	for len(data) > 0 {
+		var line []byte
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			line, data = data[:i+1], data[i+1:]
+		} else {
+			line, data = append(data, '\n'), nil
+		}
+		if name, ok := txtarMarker(line); ok {
+			if !filepath.IsLocal(name) {
+				return nil, fmt.Errorf("txtar: bad file name %q", name)
+			}
+			files = append(files, txtarFile{name: name, data: []byte{}})
+			cur = &files[len(files)-1]
+		} else if cur != nil {
+			cur.data = append(cur.data, line...)
+		}
+	}
+	return files, nil
+}
+
+// txtarMarker returns file name if line is "-- NAME --\n".
+func txtarMarker(line []byte) (string, bool) {
+	s := strings.TrimRight(string(line), "\r\n")
+	if !strings.HasPrefix(s, "-- ") || !strings.HasSuffix(s, " --") || len(s) < 6 {
+		return "", false
+	}
+	name := strings.TrimSpace(s[3 : len(s)-3])
+	return name, name != ""
+}
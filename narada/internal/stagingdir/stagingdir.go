@@ -0,0 +1,205 @@
+// Package stagingdir creates temporary Narada project directories for
+// package staging. It's separate from staging to be imported by narada
+// package (to set up default staging project in init()) without import
+// cycle.
+package stagingdir
+
+import (
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+var (
+	// BaseDir is an original directory (where test was executed).
+	BaseDir string
+	// WorkDir is a current directory (with temporary narada project).
+	WorkDir string
+)
+
+var (
+	dirs = []string{
+		".backup",
+		"config",
+		"config/backup",
+		"config/log",
+		"config/mysql",
+		"config/mysql/dump",
+		"config/qmail",
+		"tmp",
+		"var",
+		"var/log",
+		"var/use",
+		"var/mysql",
+		"var/qmail",
+	}
+	files = []struct{ name, data string }{
+		{"config/backup/exclude", "./.backup/*\n./.lock*\n./tmp/*\n./.release/*\n"},
+		{"config/log/level", "DEBUG"},
+		{"config/log/type", "file"},
+		{"config/log/file", "/dev/stdout"},
+		{"config/mysql/host", ""},
+		{"config/mysql/port", "3306"},
+		{"config/mysql/db", ""},
+		{"config/mysql/login", ""},
+		{"config/mysql/pass", ""},
+		{"config/mysql/dump/empty", ""},
+		{"config/mysql/dump/ignore", ""},
+		{"config/mysql/dump/incremental", ""},
+	}
+)
+
+func init() {
+	if flag.Lookup("test.v") != nil || strings.HasSuffix(os.Args[0], ".test") {
+		err := setUp()
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+}
+
+func setUp() (err error) {
+	BaseDir, err = os.Getwd()
+	if err != nil {
+		return err
+	}
+	WorkDir, err = ioutil.TempDir("", "narada-staging.")
+	if err != nil {
+		return err
+	}
+	err = os.Chdir(WorkDir)
+	if err != nil {
+		return err
+	}
+	err = os.Setenv("NARADA_DIR", WorkDir)
+	if err != nil {
+		return err
+	}
+	return SetUp(WorkDir)
+}
+
+// SetUp creates project in dir: default dirs and files, fixtures from
+// BaseDir/testdata/project/ and BaseDir/testdata/project.txtar, then
+// runs BaseDir/testdata/staging.setup and hooks registered by OnSetup.
+func SetUp(dir string) error {
+	err := Create(dir)
+	if err != nil {
+		return err
+	}
+	if BaseDir != "" {
+		err = CopyTree(dir, filepath.Join(BaseDir, "testdata", "project"))
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		data, err := ioutil.ReadFile(filepath.Join(BaseDir, "testdata", "project.txtar"))
+		if err == nil {
+			err = ExtractTxtar(dir, data)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	err = Run("staging.setup", dir)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	setupHooks := append([]*func(dir string) error(nil), hooks...)
+	mu.Unlock()
+	for _, hook := range setupHooks {
+		err = (*hook)(dir)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var (
+	mu    sync.Mutex
+	hooks []*func(dir string) error
+)
+
+// OnSetup registers hook to be called by SetUp. If WorkDir is already
+// set up then hook is called for it immediately. Returned remove
+// unregisters hook.
+func OnSetup(hook func(dir string) error) (remove func(), err error) {
+	ref := &hook
+	mu.Lock()
+	hooks = append(hooks, ref)
+	mu.Unlock()
+	remove = func() {
+		mu.Lock()
+		defer mu.Unlock()
+		for i := range hooks {
+			if hooks[i] == ref {
+				hooks = append(hooks[:i:i], hooks[i+1:]...)
+				break
+			}
+		}
+	}
+	if WorkDir == "" {
+		return remove, nil
+	}
+	return remove, hook(WorkDir)
+}
+
+// Create creates default project dirs and files in dir.
+func Create(dir string) (err error) {
+	for _, name := range dirs {
+		err = os.Mkdir(filepath.Join(dir, name), 0777)
+		if err != nil {
+			return err
+		}
+	}
+	for _, file := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, file.name), []byte(file.data), 0666)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Run executes BaseDir/testdata/name (if it exists) in project dir with
+// BaseDir as argument and $NARADA_DIR set to dir.
+func Run(name, dir string) error {
+	if BaseDir == "" {
+		return nil
+	}
+	custom := BaseDir + "/testdata/" + name
+	if _, err := os.Stat(custom); err != nil {
+		return nil
+	}
+	cmd := exec.Command(custom, BaseDir)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "NARADA_DIR="+dir)
+	cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
+	return cmd.Run()
+}
+
+// TearDown cleans up project created while init().
+func TearDown(exitCode int) int {
+	err := Run("staging.teardown", WorkDir)
+	if err != nil {
+		log.Print(err)
+		return exitCode
+	}
+
+	err = os.Chdir(BaseDir)
+	if err != nil {
+		log.Print(err)
+		return exitCode
+	}
+	err = os.RemoveAll(WorkDir)
+	if err != nil {
+		log.Print(err)
+		return exitCode
+	}
+	return exitCode
+}
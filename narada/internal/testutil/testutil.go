@@ -0,0 +1,20 @@
+// Package testutil contains helpers for tests of narada and its
+// subpackages.
+package testutil
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// ReadFile returns contents of file name or empty string if it doesn't
+// exist. Test fails on other errors.
+func ReadFile(t testing.TB, name string) string {
+	t.Helper()
+	buf, err := ioutil.ReadFile(name)
+	if err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
@@ -1,9 +1,16 @@
 // Package narada provides integration with Narada framework.
+//
+// All project files (locks, config, VERSION) are accessed relative to
+// project root directory, see Root.
 package narada
 
 import (
+	"context"
 	"errors"
 	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
 	"time"
 
 	"golang.org/x/sys/unix"
@@ -11,13 +18,21 @@ import (
 
 const lockfile = ".lock"
 const locknew = ".lock.new"
-const tick = time.Millisecond * 100
 
+// ErrLockTimeout is matched by *LockTimeoutError returned by SharedLock
+// and ExclusiveLock on timeout.
 var ErrLockTimeout = errors.New("failed to acquire lock: timed out")
 
+// ErrLockHeld is returned by ExclusiveLockContext and WithExclusiveLock
+// if ctx carries shared lock (see WithSharedLock): waiting for exclusive
+// lock while holding shared one is a deadlock.
+var ErrLockHeld = errors.New("failed to acquire exclusive lock: shared lock is held")
+
 // Lock is Narada lock.
 type Lock struct {
-	f *os.File
+	f    *os.File
+	mark *newMark // ".lock.new" created by ExclusiveLock.
+	gid  int64    // Goroutine which got the lock, in lock debug mode.
 }
 
 // SharedLock try to get shared lock which is required to modify any
@@ -26,32 +41,357 @@ type Lock struct {
 // If wait <= 0 will wait forever until lock will be granted.
 //
 // Do nothing if $NARADA_SKIP_LOCK is not empty.
-func SharedLock(wait time.Duration) (l Lock, err error) {
-	var waited time.Duration
-	if os.Getenv("NARADA_SKIP_LOCK") != "" {
+func SharedLock(wait time.Duration) (Lock, error) { return defaultProject.SharedLock(wait) }
+
+// SharedLock works like package-level SharedLock.
+func (p *Project) SharedLock(wait time.Duration) (Lock, error) {
+	ctx, cancel := waitContext(wait)
+	defer cancel()
+	l, err := p.SharedLockContext(ctx)
+	return l, p.timeoutErr(err)
+}
+
+// SharedLockContext works like SharedLock but will wait until lock
+// will be granted or ctx will be done (in this case ctx.Err() is
+// returned).
+//
+// Returns Lock which does nothing if ctx carries shared or exclusive
+// lock (see WithSharedLock and WithExclusiveLock).
+//
+// Do nothing if $NARADA_SKIP_LOCK is not empty.
+func SharedLockContext(ctx context.Context) (Lock, error) {
+	return defaultProject.SharedLockContext(ctx)
+}
+
+// SharedLockContext works like package-level SharedLockContext.
+func (p *Project) SharedLockContext(ctx context.Context) (l Lock, err error) {
+	if os.Getenv("NARADA_SKIP_LOCK") != "" || p.heldIn(ctx) != heldNone {
 		return
 	}
-	if l.f, err = os.OpenFile(lockfile, os.O_RDONLY|os.O_CREATE, 0644); err != nil {
+	if l.f, err = os.OpenFile(p.Path(lockfile), os.O_RDONLY|os.O_CREATE, 0644); err != nil {
 		return
 	}
+	locknew := p.Path(locknew)
 	for {
-		_, err2 := os.Stat(locknew)
-		if err2 != nil && os.IsNotExist(err2) {
-			err = unix.Flock(int(l.f.Fd()), unix.LOCK_SH|unix.LOCK_NB)
-			if err == nil || err != unix.EWOULDBLOCK {
-				return
+		if _, err = os.Stat(locknew); err == nil {
+			debugWait()
+		}
+		if err = waitNotExist(ctx, locknew); err != nil {
+			break
+		}
+		if err = flockContext(ctx, l.f, unix.LOCK_SH); err != nil {
+			break
+		}
+		_, err = os.Stat(locknew)
+		if os.IsNotExist(err) {
+			l.gid = debugAcquired()
+			return l, nil
+		}
+		// Exclusive locker appears while we was waiting, step aside.
+		if err = unix.Flock(int(l.f.Fd()), unix.LOCK_UN); err != nil {
+			break
+		}
+	}
+	_ = l.f.Close()
+	return Lock{}, err
+}
+
+// ExclusiveLock try to get exclusive lock which is required to
+// deploy/backup/restore project.
+//
+// While waiting it creates (and re-creates if needed) file ".lock.new" to
+// prevent new shared locks, and waits until all existing shared locks
+// will be released. If lock will not be granted in time ".lock.new"
+// will be removed.
+//
+// If wait <= 0 will wait forever until lock will be granted.
+//
+// Do nothing if $NARADA_SKIP_LOCK is not empty.
+func ExclusiveLock(wait time.Duration) (Lock, error) { return defaultProject.ExclusiveLock(wait) }
+
+// ExclusiveLock works like package-level ExclusiveLock.
+func (p *Project) ExclusiveLock(wait time.Duration) (Lock, error) {
+	ctx, cancel := waitContext(wait)
+	defer cancel()
+	l, err := p.ExclusiveLockContext(ctx)
+	return l, p.timeoutErr(err)
+}
+
+// ExclusiveLockContext works like ExclusiveLock but will wait until lock
+// will be granted or ctx will be done (in this case ctx.Err() is
+// returned).
+//
+// Returns ErrLockHeld if ctx carries shared lock (see WithSharedLock).
+// Returns Lock which does nothing if ctx carries exclusive lock (see
+// WithExclusiveLock).
+//
+// Do nothing if $NARADA_SKIP_LOCK is not empty.
+func ExclusiveLockContext(ctx context.Context) (Lock, error) {
+	return defaultProject.ExclusiveLockContext(ctx)
+}
+
+// ExclusiveLockContext works like package-level ExclusiveLockContext.
+func (p *Project) ExclusiveLockContext(ctx context.Context) (l Lock, err error) {
+	switch {
+	case os.Getenv("NARADA_SKIP_LOCK") != "", p.heldIn(ctx) == heldExclusive:
+		return
+	case p.heldIn(ctx) == heldShared:
+		return Lock{}, ErrLockHeld
+	}
+	if l.f, err = os.OpenFile(p.Path(lockfile), os.O_RDONLY|os.O_CREATE, 0644); err != nil {
+		return
+	}
+	l.mark = &newMark{name: p.Path(locknew)}
+	if err = l.mark.create(); err != nil {
+		_ = l.f.Close()
+		return Lock{}, err
+	}
+	keepCtx, stopKeep := context.WithCancel(ctx)
+	kept := make(chan error, 1)
+	go func() { kept <- l.mark.keep(keepCtx) }()
+	err = flockContext(ctx, l.f, unix.LOCK_EX)
+	stopKeep()
+	if errKeep := <-kept; err == nil && errKeep != nil && errKeep != context.Canceled {
+		err = errKeep
+		_ = unix.Flock(int(l.f.Fd()), unix.LOCK_UN)
+	}
+	if err == nil {
+		err = l.mark.create() // in case it was removed after keep was stopped
+	}
+	if err == nil {
+		l.gid = debugAcquired()
+		return l, nil
+	}
+	_ = l.UnLockNew()
+	_ = l.f.Close()
+	return Lock{}, err
+}
+
+// WaitExclusive waits until some process will try to get ExclusiveLock
+// (i.e. ".lock.new" will exist) or ctx is done (in this case ctx.Err()
+// is returned). Processes holding SharedLock for a long time should
+// release it after WaitExclusive returns to not block ExclusiveLock.
+//
+// Waits until ctx is done if $NARADA_SKIP_LOCK is not empty.
+func WaitExclusive(ctx context.Context) error { return defaultProject.WaitExclusive(ctx) }
+
+// WaitExclusive works like package-level WaitExclusive.
+func (p *Project) WaitExclusive(ctx context.Context) error {
+	if os.Getenv("NARADA_SKIP_LOCK") != "" {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	return waitExist(ctx, p.Path(locknew))
+}
+
+func waitContext(wait time.Duration) (context.Context, context.CancelFunc) {
+	if wait <= 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), wait)
+}
+
+// timeoutErr returns *LockTimeoutError if err is
+// context.DeadlineExceeded.
+func (p *Project) timeoutErr(err error) error {
+	if err == context.DeadlineExceeded {
+		return p.timeoutError()
+	}
+	return err
+}
+
+// flockPoll is a max delay between attempts to get flock: inotify
+// reports when ".lock" is closed, but lock may be also released using
+// LOCK_UN without closing it.
+const flockPoll = 100 * time.Millisecond
+
+// flockContext tries to apply flock operation how until it succeeds or
+// ctx is done. It doesn't block in flock(2), so nothing is left behind
+// (like goroutine holding f or lock) when ctx is done.
+func flockContext(ctx context.Context, f *os.File, how int) error {
+	fd := int(f.Fd())
+	err := unix.Flock(fd, how|unix.LOCK_NB)
+	if err != unix.EWOULDBLOCK {
+		return err
+	}
+	debugWait()
+	w, err := newWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err = w.add(f.Name(), unix.IN_CLOSE_WRITE|unix.IN_CLOSE_NOWRITE); err != nil {
+		return err
+	}
+	defer w.closeOnDone(ctx)()
+	for {
+		err = unix.Flock(fd, how|unix.LOCK_NB)
+		if err != unix.EWOULDBLOCK {
+			return err
+		}
+		err = w.f.SetReadDeadline(time.Now().Add(flockPoll))
+		if err == nil {
+			_, err = w.read()
+		}
+		if err != nil && !os.IsTimeout(err) {
+			if ctx.Err() != nil {
+				return ctx.Err()
 			}
-			err = nil
+			return err
 		}
-		if wait > 0 && waited >= wait {
-			return l, ErrLockTimeout
+	}
+}
+
+// waitNotExist waits until file name will not exist or ctx is done.
+func waitNotExist(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if _, err := os.Stat(name); os.IsNotExist(err) {
+		return nil
+	}
+	w, err := newWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err = w.add(filepath.Dir(name), unix.IN_DELETE|unix.IN_MOVED_FROM|unix.IN_ONLYDIR); err != nil {
+		return err
+	}
+	defer w.closeOnDone(ctx)()
+	for {
+		_, err = os.Stat(name)
+		if os.IsNotExist(err) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if _, err = w.read(); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+}
+
+// waitExist waits until file name will exist or ctx is done.
+func waitExist(ctx context.Context, name string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	w, err := newWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err = w.add(filepath.Dir(name), unix.IN_CREATE|unix.IN_MOVED_TO|unix.IN_ONLYDIR); err != nil {
+		return err
+	}
+	defer w.closeOnDone(ctx)()
+	for {
+		_, err = os.Stat(name)
+		if err == nil || !os.IsNotExist(err) {
+			return err
+		}
+		if _, err = w.read(); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
+		}
+	}
+}
+
+// newMark is ".lock.new" created by ExclusiveLock.
+type newMark struct {
+	name string
+	mu   sync.Mutex
+	ino  uint64 // Inode of last file created by us or 0.
+}
+
+// keep re-creates ".lock.new" every time it was removed until ctx is
+// done (it returns ctx.Err() in this case).
+func (m *newMark) keep(ctx context.Context) error {
+	w, err := newWatcher()
+	if err != nil {
+		return err
+	}
+	defer w.Close()
+	if _, err = w.add(filepath.Dir(m.name), unix.IN_DELETE|unix.IN_MOVED_FROM|unix.IN_ONLYDIR); err != nil {
+		return err
+	}
+	defer w.closeOnDone(ctx)()
+	for {
+		if err = m.create(); err != nil {
+			return err
+		}
+		if _, err = w.read(); err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			return err
 		}
-		time.Sleep(tick)
-		waited += tick
 	}
 }
 
-// UnLock free lock set by SharedLock() (or second lock set by ExclusiveLock()).
+// create creates ".lock.new" with current pid (to be reported by
+// LockDiagnostics) if it doesn't exist.
+func (m *newMark) create() error {
+	f, err := os.OpenFile(m.name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if os.IsExist(err) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	var st unix.Stat_t
+	if err = unix.Fstat(int(f.Fd()), &st); err == nil {
+		m.mu.Lock()
+		m.ino = st.Ino
+		m.mu.Unlock()
+		_, err = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+	}
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	return err
+}
+
+// remove removes ".lock.new" if it was created by us: it may be
+// re-created by another process waiting for ExclusiveLock.
+func (m *newMark) remove() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var st unix.Stat_t
+	err := unix.Stat(m.name, &st)
+	if err == unix.ENOENT || (err == nil && st.Ino != m.ino) {
+		return nil
+	} else if err != nil {
+		return &os.PathError{Op: "stat", Path: m.name, Err: err}
+	}
+	m.ino = 0
+	if err = os.Remove(m.name); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// UnLockNew free first lock set by ExclusiveLock() (i.e. remove
+// ".lock.new" to allow new shared locks). Do nothing for lock set by
+// SharedLock().
+//
+// Do nothing if $NARADA_SKIP_LOCK is not empty.
+func (l Lock) UnLockNew() error {
+	if os.Getenv("NARADA_SKIP_LOCK") != "" {
+		return nil
+	}
+	if l.mark == nil {
+		return nil
+	}
+	return l.mark.remove()
+}
+
+// UnLock free lock set by SharedLock() (or both locks set by ExclusiveLock()).
 //
 // Do nothing if $NARADA_SKIP_LOCK is not empty.
 func (l Lock) UnLock() error {
@@ -64,6 +404,10 @@ func (l Lock) UnLock() error {
 	if err := unix.Flock(int(l.f.Fd()), unix.LOCK_UN); err != nil {
 		return err
 	}
+	debugReleased(l.gid)
+	if err := l.UnLockNew(); err != nil {
+		return err
+	}
 	if err := l.f.Close(); err != nil {
 		return err
 	}
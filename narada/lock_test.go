@@ -1,6 +1,14 @@
 package narada
 
 import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
@@ -25,3 +33,407 @@ func TestSharedLock(t *testing.T) {
 		t.Errorf("lock2.UnLock(), err = %v", err)
 	}
 }
+
+const tick = time.Millisecond * 100
+
+func TestExclusiveLock(t *testing.T) {
+	shared, err := SharedLock(0)
+	if err != nil {
+		t.Fatalf("shared = SharedLock(), err = %v", err)
+	}
+	_, err = ExclusiveLock(tick)
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("ExclusiveLock(), err = %v, want %v", err, ErrLockTimeout)
+	}
+	if _, err = os.Stat(locknew); !os.IsNotExist(err) {
+		t.Errorf("after timeout os.Stat(%q), err = %v, want not exist", locknew, err)
+	}
+	if err = shared.UnLock(); err != nil {
+		t.Errorf("shared.UnLock(), err = %v", err)
+	}
+
+	excl, err := ExclusiveLock(time.Second)
+	if err != nil {
+		t.Fatalf("excl = ExclusiveLock(), err = %v", err)
+	}
+	if _, err = os.Stat(locknew); err != nil {
+		t.Errorf("os.Stat(%q), err = %v", locknew, err)
+	}
+	_, err = SharedLock(tick)
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("SharedLock(), err = %v, want %v", err, ErrLockTimeout)
+	}
+	_, err = ExclusiveLock(tick)
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("second ExclusiveLock(), err = %v, want %v", err, ErrLockTimeout)
+	}
+	if err = excl.UnLockNew(); err != nil {
+		t.Errorf("excl.UnLockNew(), err = %v", err)
+	}
+	if _, err = os.Stat(locknew); !os.IsNotExist(err) {
+		t.Errorf("after UnLockNew os.Stat(%q), err = %v, want not exist", locknew, err)
+	}
+	_, err = SharedLock(tick)
+	if !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("SharedLock() after UnLockNew, err = %v, want %v", err, ErrLockTimeout)
+	}
+	if err = excl.UnLock(); err != nil {
+		t.Errorf("excl.UnLock(), err = %v", err)
+	}
+	if err = excl.UnLock(); err != syscall.EBADF {
+		t.Errorf("excl.UnLock(), err = %v", err)
+	}
+
+	shared, err = SharedLock(tick)
+	if err != nil {
+		t.Errorf("SharedLock() after excl.UnLock(), err = %v", err)
+	}
+	if err = shared.UnLock(); err != nil {
+		t.Errorf("shared.UnLock(), err = %v", err)
+	}
+}
+
+func TestSharedLockContext(t *testing.T) {
+	excl, err := ExclusiveLock(0)
+	if err != nil {
+		t.Fatalf("excl = ExclusiveLock(), err = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(tick, cancel)
+	start := time.Now()
+	_, err = SharedLockContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("SharedLockContext(), err = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(start); elapsed > 2*tick {
+		t.Errorf("SharedLockContext() returned after %v, want about %v", elapsed, tick)
+	}
+
+	locked := make(chan error, 1)
+	var shared Lock
+	go func() {
+		var err error
+		shared, err = SharedLockContext(context.Background())
+		locked <- err
+	}()
+	if err = excl.UnLockNew(); err != nil {
+		t.Errorf("excl.UnLockNew(), err = %v", err)
+	}
+	select {
+	case err = <-locked:
+		t.Errorf("SharedLockContext() = %v, want blocked", err)
+	case <-time.After(tick):
+	}
+	start = time.Now()
+	if err = excl.UnLock(); err != nil {
+		t.Errorf("excl.UnLock(), err = %v", err)
+	}
+	if err = <-locked; err != nil {
+		t.Errorf("SharedLockContext(), err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > tick/2 {
+		t.Errorf("SharedLockContext() returned after %v, want immediately", elapsed)
+	}
+	if err = shared.UnLock(); err != nil {
+		t.Errorf("shared.UnLock(), err = %v", err)
+	}
+}
+
+func TestExclusiveLockContext(t *testing.T) {
+	shared, err := SharedLock(0)
+	if err != nil {
+		t.Fatalf("shared = SharedLock(), err = %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = ExclusiveLockContext(ctx)
+	if err != context.Canceled {
+		t.Errorf("ExclusiveLockContext(), err = %v, want %v", err, context.Canceled)
+	}
+	if _, err = os.Stat(locknew); !os.IsNotExist(err) {
+		t.Errorf("after cancel os.Stat(%q), err = %v, want not exist", locknew, err)
+	}
+
+	locked := make(chan error, 1)
+	var excl Lock
+	go func() {
+		var err error
+		excl, err = ExclusiveLockContext(context.Background())
+		locked <- err
+	}()
+	time.Sleep(tick)
+	if err = os.Remove(locknew); err != nil {
+		t.Errorf("os.Remove(%q), err = %v", locknew, err)
+	}
+	time.Sleep(tick)
+	if _, err = os.Stat(locknew); err != nil {
+		t.Errorf("os.Stat(%q), err = %v, want re-created", locknew, err)
+	}
+	start := time.Now()
+	if err = shared.UnLock(); err != nil {
+		t.Errorf("shared.UnLock(), err = %v", err)
+	}
+	if err = <-locked; err != nil {
+		t.Errorf("ExclusiveLockContext(), err = %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > tick/2 {
+		t.Errorf("ExclusiveLockContext() returned after %v, want immediately", elapsed)
+	}
+	if err = excl.UnLock(); err != nil {
+		t.Errorf("excl.UnLock(), err = %v", err)
+	}
+	if _, err = os.Stat(locknew); !os.IsNotExist(err) {
+		t.Errorf("after UnLock os.Stat(%q), err = %v, want not exist", locknew, err)
+	}
+}
+
+// lockFDs returns amount of open fds of current process for ".lock".
+func lockFDs(t *testing.T) int {
+	t.Helper()
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for _, fd := range fds {
+		if link, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); link == Path(lockfile) {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLockTimeoutCleanup(t *testing.T) {
+	shared, err := SharedLock(0)
+	if err != nil {
+		t.Fatalf("shared = SharedLock(), err = %v", err)
+	}
+	fds, goroutines := lockFDs(t), runtime.NumGoroutine()
+	for i := 0; i < 3; i++ {
+		if _, err = ExclusiveLock(tick / 10); !errors.Is(err, ErrLockTimeout) {
+			t.Errorf("ExclusiveLock(), err = %v, want %v", err, ErrLockTimeout)
+		}
+	}
+	if n := lockFDs(t); n != fds {
+		t.Errorf("after timeouts %d fds of %s are open, want %d", n, lockfile, fds)
+	}
+	for start := time.Now(); runtime.NumGoroutine() > goroutines && time.Since(start) < tick; {
+		time.Sleep(tick / 10)
+	}
+	if n := runtime.NumGoroutine(); n > goroutines {
+		t.Errorf("after timeouts %d goroutines, want %d", n, goroutines)
+	}
+
+	// Must not remove .lock.new created by another waiter.
+	if err = ioutil.WriteFile(locknew, []byte("other\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ExclusiveLock(tick / 10); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("ExclusiveLock(), err = %v, want %v", err, ErrLockTimeout)
+	}
+	if buf, err := ioutil.ReadFile(locknew); err != nil || string(buf) != "other\n" {
+		t.Errorf("after timeout %s = %q, %v, want kept", locknew, buf, err)
+	}
+	if err = os.Remove(locknew); err != nil {
+		t.Error(err)
+	}
+	if err = shared.UnLock(); err != nil {
+		t.Errorf("shared.UnLock(), err = %v", err)
+	}
+}
+
+func TestWaitExclusive(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), tick)
+	defer cancel()
+	if err := WaitExclusive(ctx); err != context.DeadlineExceeded {
+		t.Errorf("WaitExclusive(), err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	shared, err := SharedLock(0)
+	if err != nil {
+		t.Fatalf("shared = SharedLock(), err = %v", err)
+	}
+	waited := make(chan error, 1)
+	go func() { waited <- WaitExclusive(context.Background()) }()
+	excl := make(chan error, 1)
+	go func() {
+		l, err := ExclusiveLock(time.Second)
+		if err == nil {
+			err = l.UnLock()
+		}
+		excl <- err
+	}()
+	select {
+	case err = <-waited:
+		if err != nil {
+			t.Errorf("WaitExclusive(), err = %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatalf("WaitExclusive() doesn't return")
+	}
+	if err = shared.UnLock(); err != nil {
+		t.Errorf("shared.UnLock(), err = %v", err)
+	}
+	if err = <-excl; err != nil {
+		t.Errorf("ExclusiveLock(), err = %v", err)
+	}
+}
+
+// startExclusive starts ExclusiveLock in background and waits until it
+// creates ".lock.new". Returned channel gets ExclusiveLock result after
+// lock is released.
+func startExclusive(t *testing.T) <-chan error {
+	t.Helper()
+	excl := make(chan error, 1)
+	go func() {
+		l, err := ExclusiveLock(time.Second)
+		if err == nil {
+			err = l.UnLock()
+		}
+		excl <- err
+	}()
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if _, err := os.Stat(locknew); err == nil {
+			return excl
+		} else if time.Now().After(deadline) {
+			t.Fatalf("os.Stat(%q), err = %v", locknew, err)
+		}
+	}
+}
+
+func TestWithSharedLock(t *testing.T) {
+	var excl <-chan error
+	err := WithSharedLock(context.Background(), func(ctx context.Context) error {
+		excl = startExclusive(t)
+
+		ctxTimeout, cancel := context.WithTimeout(ctx, tick)
+		defer cancel()
+		l, err := SharedLockContext(ctxTimeout)
+		if err != nil {
+			t.Errorf("nested SharedLockContext(), err = %v", err)
+		}
+		if err = l.UnLock(); err != nil {
+			t.Errorf("nested UnLock(), err = %v", err)
+		}
+		if _, err = ExclusiveLockContext(ctx); err != ErrLockHeld {
+			t.Errorf("nested ExclusiveLockContext(), err = %v, want %v", err, ErrLockHeld)
+		}
+		return WithSharedLock(ctxTimeout, func(context.Context) error { return io.EOF })
+	})
+	if err != io.EOF {
+		t.Errorf("WithSharedLock(), err = %v, want %v", err, io.EOF)
+	}
+	if err = <-excl; err != nil {
+		t.Errorf("ExclusiveLock(), err = %v", err)
+	}
+
+	p, err := NewProject(Root())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = WithSharedLock(context.Background(), func(ctx context.Context) error {
+		return p.WithSharedLock(ctx, func(ctx context.Context) error {
+			if p.heldIn(ctx) != heldShared || defaultProject.heldIn(ctx) != heldShared {
+				t.Errorf("lock is not held in ctx")
+			}
+			return nil
+		})
+	})
+	if err != nil {
+		t.Errorf("WithSharedLock(), err = %v", err)
+	}
+}
+
+func TestLockContextConfig(t *testing.T) {
+	check := func(ctx context.Context) {
+		t.Helper()
+		ctx, cancel := context.WithTimeout(ctx, tick)
+		defer cancel()
+		level, err := GetConfigContext(ctx, "log/level")
+		if err != nil {
+			t.Errorf("GetConfigContext(), err = %v", err)
+		}
+		if _, _, err = LookupConfigContext(ctx, "log/level"); err != nil {
+			t.Errorf("LookupConfigContext(), err = %v", err)
+		}
+		if _, err = ReadConfigSnapshotContext(ctx, "log"); err != nil {
+			t.Errorf("ReadConfigSnapshotContext(), err = %v", err)
+		}
+		if err = SetConfigContext(ctx, "log/level", level); err != nil {
+			t.Errorf("SetConfigContext(), err = %v", err)
+		}
+		if err = ReloadLogContext(ctx); errors.Is(err, context.DeadlineExceeded) { // Lock timeout, not config error.
+			t.Errorf("ReloadLogContext(), err = %v", err)
+		}
+	}
+
+	var excl <-chan error
+	err := WithSharedLock(context.Background(), func(ctx context.Context) error {
+		excl = startExclusive(t)
+		check(ctx)
+		return WithExclusiveLock(ctx, func(context.Context) error { return nil })
+	})
+	if err != ErrLockHeld {
+		t.Errorf("WithExclusiveLock(), err = %v, want %v", err, ErrLockHeld)
+	}
+	if err = <-excl; err != nil {
+		t.Errorf("ExclusiveLock(), err = %v", err)
+	}
+
+	err = WithExclusiveLock(context.Background(), func(ctx context.Context) error {
+		if _, err := os.Stat(locknew); err != nil {
+			t.Errorf("os.Stat(%q), err = %v", locknew, err)
+		}
+		check(ctx)
+		return WithExclusiveLock(ctx, func(ctx context.Context) error {
+			return WithSharedLock(ctx, func(context.Context) error { return io.EOF })
+		})
+	})
+	if err != io.EOF {
+		t.Errorf("WithExclusiveLock(), err = %v, want %v", err, io.EOF)
+	}
+	if _, err = os.Stat(locknew); !os.IsNotExist(err) {
+		t.Errorf("os.Stat(%q), err = %v, want not exist", locknew, err)
+	}
+}
+
+func TestLockDebug(t *testing.T) {
+	var stacks []string
+	SetLockDebug(func(stack []byte) { stacks = append(stacks, string(stack)) })
+	defer SetLockDebug(nil)
+
+	shared, err := SharedLock(0)
+	if err != nil {
+		t.Fatalf("SharedLock(), err = %v", err)
+	}
+	excl := startExclusive(t)
+	if len(stacks) != 0 {
+		t.Errorf("reported without waiting: %q", stacks)
+	}
+	if _, err = SharedLock(tick); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("SharedLock(), err = %v, want %v", err, ErrLockTimeout)
+	}
+	if len(stacks) != 1 || !strings.Contains(stacks[0], "TestLockDebug") {
+		t.Errorf("reported %q, want 1 stack with TestLockDebug", stacks)
+	}
+	if err = shared.UnLock(); err != nil {
+		t.Errorf("UnLock(), err = %v", err)
+	}
+	if err = <-excl; err != nil {
+		t.Errorf("ExclusiveLock(), err = %v", err)
+	}
+
+	stacks = nil
+	shared, err = SharedLock(0)
+	if err != nil {
+		t.Fatalf("SharedLock(), err = %v", err)
+	}
+	if err = shared.UnLock(); err != nil {
+		t.Errorf("UnLock(), err = %v", err)
+	}
+	if len(stacks) != 0 || len(lockDebug.held) != 0 {
+		t.Errorf("reported %q, held %v, want nothing", stacks, lockDebug.held)
+	}
+}
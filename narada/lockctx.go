@@ -0,0 +1,71 @@
+package narada
+
+import "context"
+
+// heldLockKey is a context key for lock of project p.
+type heldLockKey struct{ p *Project }
+
+// heldLock is a kind of lock carried in context.
+type heldLock int
+
+const (
+	heldNone heldLock = iota
+	heldShared
+	heldExclusive
+)
+
+// WithSharedLock calls fn with ctx which carries shared lock of project
+// (see SharedLock). Functions which accept such ctx (SharedLockContext,
+// WithSharedLock and other *Context functions like GetConfigContext)
+// reuse this lock instead of getting new one, so they won't deadlock
+// with concurrent ExclusiveLock.
+//
+// Lock is released after fn returns. If ctx already carries shared or
+// exclusive lock then fn is just called with ctx.
+func WithSharedLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	return defaultProject.WithSharedLock(ctx, fn)
+}
+
+// WithSharedLock works like package-level WithSharedLock.
+func (p *Project) WithSharedLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	if p.heldIn(ctx) != heldNone {
+		return fn(ctx)
+	}
+	lock, err := p.SharedLockContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.UnLock()
+	return fn(context.WithValue(ctx, heldLockKey{p}, heldShared))
+}
+
+// WithExclusiveLock works like WithSharedLock but ctx carries exclusive
+// lock (see ExclusiveLock). Functions which need shared lock will reuse
+// it too, so fn may read config while holding exclusive lock.
+//
+// Returns ErrLockHeld if ctx already carries shared lock.
+func WithExclusiveLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	return defaultProject.WithExclusiveLock(ctx, fn)
+}
+
+// WithExclusiveLock works like package-level WithExclusiveLock.
+func (p *Project) WithExclusiveLock(ctx context.Context, fn func(ctx context.Context) error) error {
+	switch p.heldIn(ctx) {
+	case heldExclusive:
+		return fn(ctx)
+	case heldShared:
+		return ErrLockHeld
+	}
+	lock, err := p.ExclusiveLockContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.UnLock()
+	return fn(context.WithValue(ctx, heldLockKey{p}, heldExclusive))
+}
+
+// heldIn returns kind of p's lock carried by ctx.
+func (p *Project) heldIn(ctx context.Context) heldLock {
+	held, _ := ctx.Value(heldLockKey{p}).(heldLock)
+	return held
+}
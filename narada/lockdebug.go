@@ -0,0 +1,88 @@
+package narada
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+)
+
+var lockDebug = struct {
+	sync.Mutex
+	report func(stack []byte)
+	held   map[int64]int // Amount of held locks by goroutine ID.
+}{held: make(map[int64]int)}
+
+func init() {
+	if os.Getenv("NARADA_LOCK_DEBUG") != "" {
+		SetLockDebug(func(stack []byte) {
+			log.Printf("narada: waiting for lock while holding another lock:\n%s", stack)
+		})
+	}
+}
+
+// SetLockDebug enables lock debug mode: report will be called with a
+// stack trace every time some goroutine is going to wait for a lock
+// while it's already holding another lock (this is likely a deadlock).
+// Nil report disables debug mode.
+//
+// Debug mode is enabled on start (reporting using log package) if
+// $NARADA_LOCK_DEBUG is not empty.
+func SetLockDebug(report func(stack []byte)) {
+	lockDebug.Lock()
+	defer lockDebug.Unlock()
+	lockDebug.report = report
+	if report == nil {
+		lockDebug.held = make(map[int64]int)
+	}
+}
+
+// debugAcquired should be called after getting lock by current
+// goroutine. It returns goroutine ID to be passed to debugReleased
+// or 0 if debug mode is disabled.
+func debugAcquired() int64 {
+	lockDebug.Lock()
+	defer lockDebug.Unlock()
+	if lockDebug.report == nil {
+		return 0
+	}
+	gid := goroutineID()
+	lockDebug.held[gid]++
+	return gid
+}
+
+func debugReleased(gid int64) {
+	if gid == 0 {
+		return
+	}
+	lockDebug.Lock()
+	defer lockDebug.Unlock()
+	if lockDebug.held[gid]--; lockDebug.held[gid] <= 0 {
+		delete(lockDebug.held, gid)
+	}
+}
+
+// debugWait should be called before current goroutine will wait for
+// lock.
+func debugWait() {
+	lockDebug.Lock()
+	report := lockDebug.report
+	held := report != nil && lockDebug.held[goroutineID()] > 0
+	lockDebug.Unlock()
+	if held {
+		buf := make([]byte, 64<<10)
+		report(buf[:runtime.Stack(buf, false)])
+	}
+}
+
+func goroutineID() int64 {
+	var buf [64]byte
+	b := bytes.TrimPrefix(buf[:runtime.Stack(buf[:], false)], []byte("goroutine "))
+	if i := bytes.IndexByte(b, ' '); i > 0 {
+		b = b[:i]
+	}
+	id, _ := strconv.ParseInt(string(b), 10, 64)
+	return id
+}
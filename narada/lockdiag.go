@@ -0,0 +1,275 @@
+package narada
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+var procDir = "/proc"
+
+// LockTimeoutError is returned by SharedLock and ExclusiveLock on
+// timeout. It describes who prevented getting the lock and matches
+// ErrLockTimeout, so use errors.Is(err, ErrLockTimeout) to check for it.
+type LockTimeoutError struct {
+	Status *LockStatus // Nil if failed to get lock status.
+}
+
+func (e *LockTimeoutError) Error() string {
+	if e.Status == nil {
+		return ErrLockTimeout.Error()
+	}
+	return ErrLockTimeout.Error() + ": " + e.Status.String()
+}
+
+// Is returns true if target is ErrLockTimeout.
+func (e *LockTimeoutError) Is(target error) bool { return target == ErrLockTimeout }
+
+var lockTimeout = struct {
+	sync.Mutex
+	report func(err *LockTimeoutError)
+}{}
+
+// SetLockTimeoutReport sets function which will be called with error
+// every time SharedLock or ExclusiveLock returns *LockTimeoutError,
+// for example to log who prevented getting the lock even if caller
+// ignores returned error. Nil report (default) disables reporting.
+func SetLockTimeoutReport(report func(err *LockTimeoutError)) {
+	lockTimeout.Lock()
+	defer lockTimeout.Unlock()
+	lockTimeout.report = report
+}
+
+// timeoutError returns *LockTimeoutError with p's lock status and
+// reports it using lock timeout report (if any).
+func (p *Project) timeoutError() *LockTimeoutError {
+	status, _ := p.LockDiagnostics()
+	err := &LockTimeoutError{Status: status}
+	lockTimeout.Lock()
+	report := lockTimeout.report
+	lockTimeout.Unlock()
+	if report != nil {
+		report(err)
+	}
+	return err
+}
+
+// LockStatus describes processes which use project lock.
+type LockStatus struct {
+	// Processes which hold ".lock" or wait for it, sorted by PID.
+	Processes []LockProcess
+	// New describes ".lock.new" or nil if it doesn't exist.
+	New *LockNew
+}
+
+// LockProcess describes process which has ".lock" open.
+type LockProcess struct {
+	PID     int
+	Cmdline []string // Nil if unknown.
+	// Exclusive is true if process holds (or waits for) exclusive lock.
+	Exclusive bool
+	// Waiting is true if process doesn't hold lock yet (it either
+	// waits for flock or for removing ".lock.new").
+	Waiting bool
+	// Self is true for current process (it may block itself, e.g. by
+	// waiting for exclusive lock while holding shared one).
+	Self bool
+}
+
+// LockNew describes ".lock.new" created by ExclusiveLock.
+type LockNew struct {
+	PID     int      // Zero if unknown.
+	Cmdline []string // Nil if unknown or creator doesn't exist anymore.
+	Created time.Time
+	Self    bool // True if created by current process.
+}
+
+// Age returns time since ".lock.new" was created.
+func (n *LockNew) Age() time.Duration {
+	return time.Since(n.Created)
+}
+
+func (s *LockStatus) String() string {
+	var b strings.Builder
+	if len(s.Processes) == 0 {
+		b.WriteString("no processes hold lock")
+	} else {
+		b.WriteString("lock is used by")
+	}
+	for i, proc := range s.Processes {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		kind := "shared"
+		if proc.Exclusive {
+			kind = "exclusive"
+		}
+		if proc.Waiting {
+			kind = "waiting"
+		}
+		if proc.Self {
+			kind = "self " + kind
+		}
+		fmt.Fprintf(&b, " pid %d %s (%s)", proc.PID, kind, cmdlineString(proc.Cmdline))
+	}
+	if s.New != nil {
+		fmt.Fprintf(&b, "; %s created %v ago", locknew, s.New.Age().Round(time.Second))
+		if s.New.Self {
+			fmt.Fprintf(&b, " by self pid %d (%s)", s.New.PID, cmdlineString(s.New.Cmdline))
+		} else if s.New.PID != 0 {
+			fmt.Fprintf(&b, " by pid %d (%s)", s.New.PID, cmdlineString(s.New.Cmdline))
+		}
+	}
+	return b.String()
+}
+
+func cmdlineString(cmdline []string) string {
+	if cmdline == nil {
+		return "unknown"
+	}
+	return strings.Join(cmdline, " ")
+}
+
+// LockDiagnostics returns information about processes which hold or
+// wait for project lock (using /proc/locks and /proc/*/fd) and creator
+// of ".lock.new". Processes of other users may be missing if current
+// user isn't allowed to inspect them.
+func LockDiagnostics() (*LockStatus, error) { return defaultProject.LockDiagnostics() }
+
+// LockDiagnostics works like package-level LockDiagnostics.
+func (p *Project) LockDiagnostics() (*LockStatus, error) {
+	s := &LockStatus{}
+	procs := make(map[int]*LockProcess)
+
+	name := p.Path(lockfile)
+	fi, err := os.Stat(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err = scanProcLocks(fi, procs); err != nil {
+			return nil, err
+		}
+		if err = scanProcFDs(name, procs); err != nil {
+			return nil, err
+		}
+	}
+	self := os.Getpid()
+	for pid, proc := range procs {
+		proc.Cmdline = readCmdline(pid)
+		proc.Self = pid == self
+		s.Processes = append(s.Processes, *proc)
+	}
+	sort.Slice(s.Processes, func(i, j int) bool { return s.Processes[i].PID < s.Processes[j].PID })
+
+	name = p.Path(locknew)
+	fi, err = os.Stat(name)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		s.New = &LockNew{Created: fi.ModTime()}
+		buf, _ := ioutil.ReadFile(name)
+		if pid, err := strconv.Atoi(string(bytes.TrimSpace(buf))); err == nil && pid > 0 {
+			s.New.PID = pid
+			s.New.Cmdline = readCmdline(pid)
+			s.New.Self = pid == self
+		}
+	}
+	return s, nil
+}
+
+// scanProcLocks adds to procs processes which have flock on file fi.
+//
+// Format of /proc/locks lines (second one is waiting for lock):
+//
+//	1: FLOCK  ADVISORY  READ 21189 fe:00:9621238 0 EOF
+//	1: -> FLOCK  ADVISORY  WRITE 21190 fe:00:9621238 0 EOF
+func scanProcLocks(fi os.FileInfo, procs map[int]*LockProcess) error {
+	st, ok := fi.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	dev := uint64(st.Dev) // nolint:unconvert // Type differs between arch.
+	id := fmt.Sprintf("%02x:%02x:%d", unix.Major(dev), unix.Minor(dev), st.Ino)
+
+	f, err := os.Open(filepath.Join(procDir, "locks"))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		waiting := len(fields) > 1 && fields[1] == "->"
+		if waiting {
+			fields = append(fields[:1], fields[2:]...)
+		}
+		if len(fields) < 6 || fields[1] != "FLOCK" || fields[5] != id {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[4])
+		if err != nil {
+			continue
+		}
+		excl := fields[3] == "WRITE"
+		switch proc := procs[pid]; {
+		case proc == nil:
+			procs[pid] = &LockProcess{PID: pid, Exclusive: excl, Waiting: waiting}
+		case proc.Waiting && !waiting:
+			*proc = LockProcess{PID: pid, Exclusive: excl}
+		case proc.Waiting == waiting:
+			proc.Exclusive = proc.Exclusive || excl
+		}
+	}
+	return scanner.Err()
+}
+
+// scanProcFDs adds to procs (as waiting) processes which have file name
+// open but doesn't hold flock on it.
+func scanProcFDs(name string, procs map[int]*LockProcess) error {
+	if realName, err := filepath.EvalSymlinks(name); err == nil {
+		name = realName
+	}
+	dirs, err := ioutil.ReadDir(procDir)
+	if err != nil {
+		return err
+	}
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil || procs[pid] != nil {
+			continue
+		}
+		fdDir := filepath.Join(procDir, dir.Name(), "fd")
+		fds, err := ioutil.ReadDir(fdDir)
+		if err != nil {
+			continue // Process exited or belongs to other user.
+		}
+		for _, fd := range fds {
+			if link, err := os.Readlink(filepath.Join(fdDir, fd.Name())); err == nil && link == name {
+				procs[pid] = &LockProcess{PID: pid, Waiting: true}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+func readCmdline(pid int) []string {
+	buf, err := ioutil.ReadFile(filepath.Join(procDir, strconv.Itoa(pid), "cmdline"))
+	if err != nil || len(buf) == 0 {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(string(buf), "\x00"), "\x00")
+}
@@ -0,0 +1,167 @@
+package narada
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestLockDiagnostics(t *testing.T) {
+	status, err := LockDiagnostics()
+	if err != nil {
+		t.Fatalf("LockDiagnostics(), err = %v", err)
+	}
+	if len(status.Processes) != 0 || status.New != nil {
+		t.Errorf("LockDiagnostics() = %+v, want nothing", status)
+	}
+
+	shared, err := SharedLock(0)
+	if err != nil {
+		t.Fatalf("SharedLock(), err = %v", err)
+	}
+	excl := startExclusive(t)
+	status, err = LockDiagnostics()
+	if err != nil {
+		t.Fatalf("LockDiagnostics(), err = %v", err)
+	}
+	pid := os.Getpid()
+	if len(status.Processes) != 1 {
+		t.Errorf("LockDiagnostics().Processes = %+v, want only current process", status.Processes)
+	} else if proc := status.Processes[0]; proc.PID != pid || proc.Exclusive || proc.Waiting || len(proc.Cmdline) == 0 {
+		t.Errorf("LockDiagnostics().Processes[0] = %+v", proc)
+	}
+	if status.New == nil || status.New.PID != pid || len(status.New.Cmdline) == 0 || status.New.Age() < 0 {
+		t.Errorf("LockDiagnostics().New = %+v", status.New)
+	}
+
+	var reported []*LockTimeoutError
+	SetLockTimeoutReport(func(err *LockTimeoutError) { reported = append(reported, err) })
+	defer SetLockTimeoutReport(nil)
+
+	_, err = SharedLock(tick)
+	var timeoutErr *LockTimeoutError
+	if !errors.As(err, &timeoutErr) || !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("SharedLock(), err = %v, want %T", err, timeoutErr)
+	} else if want := fmt.Sprintf("%s created 0s ago by self pid %d", locknew, pid); !strings.Contains(err.Error(), want) {
+		t.Errorf("SharedLock(), err = %q, want it to contain %q", err, want)
+	}
+	if len(reported) != 1 || reported[0] != timeoutErr {
+		t.Errorf("reported %v, want %v", reported, err)
+	}
+
+	if err = shared.UnLock(); err != nil {
+		t.Errorf("UnLock(), err = %v", err)
+	}
+	if err = <-excl; err != nil {
+		t.Errorf("ExclusiveLock(), err = %v", err)
+	}
+
+	// Deadlock inside current process must be reported.
+	SetLockTimeoutReport(nil)
+	shared, err = SharedLock(0)
+	if err != nil {
+		t.Fatalf("SharedLock(), err = %v", err)
+	}
+	_, err = ExclusiveLock(tick)
+	if !errors.As(err, &timeoutErr) || timeoutErr.Status == nil {
+		t.Errorf("ExclusiveLock(), err = %v, want %T", err, timeoutErr)
+	} else if procs := timeoutErr.Status.Processes; len(procs) != 1 || procs[0].PID != pid || !procs[0].Self {
+		t.Errorf("ExclusiveLock(), err = %v, want current process as self", err)
+	} else if want := fmt.Sprintf("pid %d self shared", pid); !strings.Contains(err.Error(), want) {
+		t.Errorf("ExclusiveLock(), err = %q, want it to contain %q", err, want)
+	}
+	if len(reported) != 1 {
+		t.Errorf("reported %v after disabling report", reported)
+	}
+	if err = shared.UnLock(); err != nil {
+		t.Errorf("UnLock(), err = %v", err)
+	}
+}
+
+func TestScanProcLocks(t *testing.T) {
+	dir := t.TempDir()
+	name := filepath.Join(dir, lockfile)
+	if err := ioutil.WriteFile(name, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := fi.Sys().(*syscall.Stat_t)
+	dev := uint64(st.Dev) // nolint:unconvert // Type differs between arch.
+	id := fmt.Sprintf("%02x:%02x:%d", unix.Major(dev), unix.Minor(dev), st.Ino)
+
+	defer func(dir string) { procDir = dir }(procDir)
+	procDir = filepath.Join(dir, "proc")
+	locks := "" +
+		"1: POSIX  ADVISORY  WRITE 10 " + id + " 0 EOF\n" +
+		"2: FLOCK  ADVISORY  READ 11 " + id + " 0 EOF\n" +
+		"2: -> FLOCK  ADVISORY  WRITE 11 " + id + " 0 EOF\n" +
+		"2: -> FLOCK  ADVISORY  WRITE 12 " + id + " 0 EOF\n" +
+		"3: FLOCK  ADVISORY  READ 13 00:00:1 0 EOF\n" +
+		"4: -> FLOCK  ADVISORY  READ 14 " + id + " 0 EOF\n" +
+		"4: FLOCK  ADVISORY  WRITE 14 " + id + " 0 EOF\n"
+	for _, pid := range []string{"", "11", "15", "16"} {
+		if err = os.MkdirAll(filepath.Join(procDir, pid, "fd"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = ioutil.WriteFile(filepath.Join(procDir, "locks"), []byte(locks), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(filepath.Join(procDir, "11", "cmdline"), []byte("prog\x00-v\x00"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, pid := range []string{"11", "15"} {
+		if err = os.Symlink(name, filepath.Join(procDir, pid, "fd", "3")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Symlink("/dev/null", filepath.Join(procDir, "16", "fd", "3")); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewProject(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, err := p.LockDiagnostics()
+	if err != nil {
+		t.Fatalf("LockDiagnostics(), err = %v", err)
+	}
+	want := []LockProcess{
+		{PID: 11, Cmdline: []string{"prog", "-v"}},
+		{PID: 12, Exclusive: true, Waiting: true},
+		{PID: 14, Exclusive: true},
+		{PID: 15, Waiting: true},
+	}
+	if !reflect.DeepEqual(status.Processes, want) {
+		t.Errorf("LockDiagnostics().Processes = %+v, want %+v", status.Processes, want)
+	}
+
+	if err = ioutil.WriteFile(filepath.Join(dir, locknew), []byte(strconv.Itoa(11)+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	status, err = p.LockDiagnostics()
+	if err != nil {
+		t.Fatalf("LockDiagnostics(), err = %v", err)
+	}
+	if status.New == nil || status.New.PID != 11 || !reflect.DeepEqual(status.New.Cmdline, []string{"prog", "-v"}) {
+		t.Errorf("LockDiagnostics().New = %+v", status.New)
+	}
+	wantStr := "lock is used by pid 11 shared (prog -v), pid 12 waiting (unknown), " +
+		"pid 14 exclusive (unknown), pid 15 waiting (unknown); .lock.new created 0s ago by pid 11 (prog -v)"
+	if got := status.String(); got != wantStr {
+		t.Errorf("String() = %q, want %q", got, wantStr)
+	}
+}
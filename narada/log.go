@@ -1,69 +1,180 @@
 package narada
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
 	"log/syslog"
 	"os"
+	"os/signal"
 	"path"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
 )
 
-var logLevel = LogDEBUG
-var syslogLogger *syslog.Writer
+// logState is a log configuration. It's immutable and replaced as a
+// whole on reload to make it safe to log while reloading.
+// Replaced state is closed only after all in-flight writes are done.
+type logState struct {
+	level  LogLevel
+	syslog *syslog.Writer
+	file   *fileLog
+	mu     sync.RWMutex // Held for reading while writing to syslog/file.
+	closed bool
+}
 
-var InitLogError = initLog()
+// InitLogError contains result of loading log configuration of default
+// project at package initialization. It isn't updated by ReloadLog.
+var InitLogError = defaultProject.initLog()
 
-// initLog is not thread-safe.
-func initLog() (err error) { // nolint:gocyclo
-	defer func() {
-		if err != nil {
-			logLevel = LogDEBUG
-			syslogLogger = nil
-		}
-	}()
+// initLog reset log to defaults (level DEBUG, log package output) on
+// error.
+func (p *Project) initLog() error {
+	p.reloadLogMu.Lock()
+	defer p.reloadLogMu.Unlock()
+	l, err := p.loadLog(context.Background())
+	if err != nil {
+		l = &logState{level: LogDEBUG}
+	}
+	p.setLog(l)
+	return err
+}
+
+// ReloadLog re-reads log configuration from config/log/* and applies it.
+// It is safe to call it concurrently with logging.
+// On error current log configuration is kept unchanged.
+func ReloadLog() error { return defaultProject.ReloadLog() }
+
+// ReloadLog works like package-level ReloadLog.
+func (p *Project) ReloadLog() error {
+	return p.ReloadLogContext(context.Background())
+}
+
+// ReloadLogContext works like ReloadLog but gets shared lock using
+// SharedLockContext(ctx), so it may be called while ctx carries lock (see
+// WithSharedLock and WithExclusiveLock).
+func ReloadLogContext(ctx context.Context) error { return defaultProject.ReloadLogContext(ctx) }
 
-	lock, err := SharedLock(0)
+// ReloadLogContext works like package-level ReloadLogContext.
+func (p *Project) ReloadLogContext(ctx context.Context) error {
+	p.reloadLogMu.Lock()
+	defer p.reloadLogMu.Unlock()
+	l, err := p.loadLog(ctx)
 	if err != nil {
 		return err
 	}
+	p.setLog(l)
+	return nil
+}
+
+func (p *Project) getLog() *logState {
+	if l := p.log.Load(); l != nil {
+		return l
+	}
+	return &logState{level: LogDEBUG}
+}
+
+func (p *Project) setLog(l *logState) {
+	if old := p.log.Swap(l); old != nil {
+		old.close()
+	}
+}
+
+// acquireLog returns current log state locked for writing to it.
+// Caller must call l.mu.RUnlock when done.
+func (p *Project) acquireLog() *logState {
+	for {
+		l := p.getLog()
+		l.mu.RLock()
+		if !l.closed {
+			return l
+		}
+		l.mu.RUnlock() // It was replaced, so next getLog returns new one.
+	}
+}
+
+// close waits for in-flight writes and closes syslog/file.
+func (l *logState) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	if l.syslog != nil {
+		l.syslog.Close()
+	}
+	if l.file != nil {
+		l.file.Close()
+	}
+}
+
+func (p *Project) loadLog(ctx context.Context) (*logState, error) { // nolint:gocyclo
+	lock, err := p.SharedLockContext(ctx)
+	if err != nil {
+		return nil, err
+	}
 	defer lock.UnLock()
+	cfg := configReader{lookup: p.readConfigFile} // Already locked.
 
-	output := GetConfigLine("log/output")
-	if len(output) == 0 {
-		return errors.New("require non-empty config/log/output")
+	level, _, err := cfg.LookupConfigLine("log/level")
+	if err != nil {
+		return nil, err
+	}
+	logtype, _, err := cfg.LookupConfigLine("log/type")
+	if err != nil {
+		return nil, err
 	}
 
-	switch level := GetConfigLine("log/level"); level {
+	l := &logState{}
+	switch level {
 	case "ERR":
-		logLevel = LogERR
+		l.level = LogERR
 	case "WARN":
-		logLevel = LogWARN
+		l.level = LogWARN
 	case "NOTICE":
-		logLevel = LogNOTICE
+		l.level = LogNOTICE
 	case "INFO":
-		logLevel = LogINFO
+		l.level = LogINFO
 	case "DEBUG":
-		logLevel = LogDEBUG
+		l.level = LogDEBUG
 	default:
-		return errors.New("unsupported config/log/level: " + level)
+		return nil, errors.New("unsupported config/log/level: " + level)
 	}
 
-	logtype := GetConfigLine("log/type")
+	var output, file string
 	switch logtype {
-	case "":
-		// logtype = "syslog"
-	case "syslog":
+	case "", "syslog":
+		output, _, err = cfg.LookupConfigLine("log/output")
+		if err != nil {
+			return nil, err
+		}
+		if len(output) == 0 {
+			return nil, errors.New("require non-empty config/log/output")
+		}
+		l.syslog, err = syslog.Dial("unixgram", p.Path(output), syslog.LOG_NOTICE|syslog.LOG_USER, path.Base(os.Args[0]))
+		if err != nil {
+			return nil, err
+		}
+	case "file":
+		file, _, err = cfg.LookupConfigLine("log/file")
+		if err != nil {
+			return nil, err
+		}
+		if len(file) == 0 {
+			return nil, errors.New("require non-empty config/log/file")
+		}
+		if l.file, err = openFileLog(p.Path(file)); err != nil {
+			return nil, err
+		}
 	default:
-		return errors.New("unsupported config/log/type: " + logtype)
+		return nil, errors.New("unsupported config/log/type: " + logtype)
 	}
 
-	syslogLogger, err = syslog.Dial("unixgram", output, syslog.LOG_NOTICE|syslog.LOG_USER, path.Base(os.Args[0]))
-	if err != nil {
-		return err
-	}
 	log.SetFlags(0)
-	return nil
+	return l, nil
 }
 
 type LogLevel byte
@@ -93,17 +204,39 @@ func (level LogLevel) String() string {
 }
 
 type Log struct {
+	p      *Project // nil means default project
 	prefix string
+	fields string // already rendered in logfmt
 }
 
 func NewLog(prefix string) *Log {
-	return &Log{prefix: prefix}
+	return defaultProject.NewLog(prefix)
+}
+
+// NewLog works like package-level NewLog.
+func (p *Project) NewLog(prefix string) *Log {
+	return &Log{p: p, prefix: prefix}
+}
+
+func (l Log) project() *Project {
+	if l.p == nil {
+		return defaultProject
+	}
+	return l.p
 }
 
 func (l Log) Prefix() string {
 	return l.prefix
 }
 
+// With returns a copy of l which will add given key/value pairs to each
+// message. Pairs are rendered in logfmt after the message, in the same
+// order as they was added.
+func (l Log) With(keyvals ...interface{}) *Log {
+	l.fields = appendFields(l.fields, keyvals)
+	return &l
+}
+
 func (l Log) Print(v ...interface{}) {
 	l.write(LogNOTICE, fmt.Sprint(v...))
 }
@@ -169,33 +302,181 @@ func (l Log) DEBUG(format string, v ...interface{}) {
 	l.write(LogDEBUG, format, v...)
 }
 
+// ERRw log msg followed by key/value pairs.
+func (l Log) ERRw(msg string, keyvals ...interface{}) {
+	l.writew(LogERR, msg, keyvals)
+}
+
+// WARNw log msg followed by key/value pairs.
+func (l Log) WARNw(msg string, keyvals ...interface{}) {
+	l.writew(LogWARN, msg, keyvals)
+}
+
+// NOTICEw log msg followed by key/value pairs.
+func (l Log) NOTICEw(msg string, keyvals ...interface{}) {
+	l.writew(LogNOTICE, msg, keyvals)
+}
+
+// INFOw log msg followed by key/value pairs.
+func (l Log) INFOw(msg string, keyvals ...interface{}) {
+	l.writew(LogINFO, msg, keyvals)
+}
+
+// DEBUGw log msg followed by key/value pairs.
+func (l Log) DEBUGw(msg string, keyvals ...interface{}) {
+	l.writew(LogDEBUG, msg, keyvals)
+}
+
+func (l Log) writew(level LogLevel, msg string, keyvals []interface{}) {
+	if l.project().getLog().level > level {
+		return
+	}
+	l.output(level, msg, appendFields(l.fields, keyvals))
+}
+
 func (l Log) write(level LogLevel, msg string, v ...interface{}) {
-	if logLevel > level {
+	if l.project().getLog().level > level {
 		return
 	}
 	if len(v) != 0 {
 		msg = fmt.Sprintf(msg, v...)
 	}
+	l.output(level, msg, l.fields)
+}
+
+func (l Log) output(level LogLevel, msg string, fields string) {
 	msg = l.prefix + msg
+	if fields != "" {
+		msg = strings.TrimRight(msg, "\n") + " " + fields
+	}
+	l.project().writeLog(level, msg, stdlogFallback)
+}
 
-	if syslogLogger == nil {
-		log.Print(level.String() + ": " + msg)
-	} else {
+// writeLog sends msg to configured log or to fallback if log is not
+// configured or failed.
+func (p *Project) writeLog(level LogLevel, msg string, fallback func(LogLevel, string)) {
+	l := p.acquireLog()
+	defer l.mu.RUnlock()
+	switch {
+	case l.file != nil:
+		if err := l.file.write(level, msg); err != nil {
+			fallback(level, msg)
+		}
+	case l.syslog == nil:
+		fallback(level, msg)
+	default:
 		var err error
 		switch level {
 		case LogDEBUG:
-			err = syslogLogger.Debug(msg)
+			err = l.syslog.Debug(msg)
 		case LogINFO:
-			err = syslogLogger.Info(msg)
+			err = l.syslog.Info(msg)
 		case LogNOTICE:
-			err = syslogLogger.Notice(msg)
+			err = l.syslog.Notice(msg)
 		case LogWARN:
-			err = syslogLogger.Warning(msg)
+			err = l.syslog.Warning(msg)
 		default:
-			err = syslogLogger.Err(msg)
+			err = l.syslog.Err(msg)
 		}
 		if err != nil {
-			log.Print(level.String() + ": " + msg)
+			fallback(level, msg)
+		}
+	}
+}
+
+func stdlogFallback(level LogLevel, msg string) {
+	log.Print(level.String() + ": " + msg)
+}
+
+// logReloadDelay is used to merge bursts of changes in config/log/*.
+const logReloadDelay = 100 * time.Millisecond
+
+// WatchLog starts watching for SIGHUP and changes in config/log/* files
+// and calls ReloadLog on each of them until ctx is done.
+// Errors returned by ReloadLog are reported using onError (if not nil).
+//
+// Log file (config/log/type "file") is reopened on SIGHUP even if
+// ReloadLog fails, to support logrotate. This package doesn't handle
+// SIGHUP without WatchLog.
+//
+// It returns error only if it failed to start watching.
+func WatchLog(ctx context.Context, onError func(error)) error {
+	return defaultProject.WatchLog(ctx, onError)
+}
+
+// WatchLog works like package-level WatchLog.
+func (p *Project) WatchLog(ctx context.Context, onError func(error)) error {
+	w, err := newWatcher()
+	if err != nil {
+		return err
+	}
+	const mask = unix.IN_CLOSE_WRITE | unix.IN_CREATE | unix.IN_DELETE | unix.IN_MOVED_TO | unix.IN_MOVED_FROM | unix.IN_ONLYDIR
+	if _, err = w.add(p.Path(configDir+"log"), mask); err != nil {
+		w.Close()
+		return err
+	}
+	if onError == nil {
+		onError = func(error) {}
+	}
+
+	changed := make(chan struct{}, 1)
+	go func() {
+		defer w.closeOnDone(ctx)()
+		for {
+			if _, err := w.read(); err != nil {
+				if ctx.Err() == nil {
+					onError(err)
+				}
+				return
+			}
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		}
+	}()
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(hup)
+		defer w.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hup:
+				if err := p.ReloadLog(); err != nil {
+					onError(err)
+					p.reopenLog(onError)
+				}
+				continue
+			case <-changed:
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(logReloadDelay):
+				}
+				select {
+				case <-changed:
+				default:
+				}
+			}
+			if err := p.ReloadLog(); err != nil {
+				onError(err)
+			}
+		}
+	}()
+	return nil
+}
+
+// reopenLog reopens current log file (if any).
+func (p *Project) reopenLog(onError func(error)) {
+	l := p.acquireLog()
+	defer l.mu.RUnlock()
+	if l.file != nil {
+		if err := l.file.reopen(); err != nil {
+			onError(err)
 		}
 	}
 }
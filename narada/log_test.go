@@ -3,13 +3,19 @@ package narada
 import (
 	"bufio"
 	"bytes"
+	"context"
 	"errors"
+	"io/ioutil"
 	"log"
 	"os"
 	"os/exec"
+	"path"
+	"path/filepath"
 	"reflect"
 	"regexp"
 	"strconv"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 )
@@ -51,7 +57,7 @@ WAIT:
 			log.Fatal(err)
 		}
 	}
-	InitLogError = initLog()
+	InitLogError = defaultProject.initLog()
 }
 
 func fakeLogStop() {
@@ -64,7 +70,7 @@ func fakeLogStop() {
 
 func TestInitLog(t *testing.T) {
 	// error text for Go >= 1.5
-	errDialUnix := errors.New("dial unixgram var/log.sock: connect: no such file or directory")
+	errDialUnix := errors.New("dial unixgram " + Path("var/log.sock") + ": connect: no such file or directory")
 
 	cases := []struct {
 		setup   func()
@@ -73,48 +79,58 @@ func TestInitLog(t *testing.T) {
 		wanterr error
 	}{
 		{
-			func() {},
+			func() {
+				FakeConfig(map[string]string{"log/output": ""})
+				InitLogError = defaultProject.initLog()
+			},
 			LogDEBUG, false, errors.New("require non-empty config/log/output"),
 		},
 		{
 			func() {
 				FakeConfig(map[string]string{"log/level": ""})
-				InitLogError = initLog()
+				InitLogError = defaultProject.initLog()
 			},
 			LogDEBUG, false, errors.New("unsupported config/log/level: "),
 		},
 		{
 			func() {
 				FakeConfig(map[string]string{"log/level": "bad"})
-				InitLogError = initLog()
+				InitLogError = defaultProject.initLog()
 			},
 			LogDEBUG, false, errors.New("unsupported config/log/level: bad"),
 		},
 		{
 			func() {
 				FakeConfig(map[string]string{"log/type": "bad"})
-				InitLogError = initLog()
+				InitLogError = defaultProject.initLog()
 			},
 			LogDEBUG, false, errors.New("unsupported config/log/type: bad"),
 		},
 		{
 			func() {
 				FakeConfig(map[string]string{"log/type": "file"})
-				InitLogError = initLog()
+				InitLogError = defaultProject.initLog()
 			},
-			LogDEBUG, false, errors.New("unsupported config/log/type: file"),
+			LogDEBUG, false, errors.New("require non-empty config/log/file"),
+		},
+		{
+			func() {
+				FakeConfig(map[string]string{"log/type": "file", "log/file": "nosuch/log"})
+				InitLogError = defaultProject.initLog()
+			},
+			LogDEBUG, false, errors.New("open " + Path("nosuch/log") + ": no such file or directory"),
 		},
 		{
 			func() {
 				FakeConfig(map[string]string{"log/type": ""})
-				InitLogError = initLog()
+				InitLogError = defaultProject.initLog()
 			},
 			LogDEBUG, false, errDialUnix,
 		},
 		{
 			func() {
 				FakeConfig(map[string]string{"log/type": "syslog"})
-				InitLogError = initLog()
+				InitLogError = defaultProject.initLog()
 			},
 			LogDEBUG, false, errDialUnix,
 		},
@@ -125,35 +141,35 @@ func TestInitLog(t *testing.T) {
 		{
 			func() {
 				FakeConfig(map[string]string{"log/level": "ERR"})
-				InitLogError = initLog()
+				InitLogError = defaultProject.initLog()
 			},
 			LogERR, true, nil,
 		},
 		{
 			func() {
 				FakeConfig(map[string]string{"log/level": "WARN"})
-				InitLogError = initLog()
+				InitLogError = defaultProject.initLog()
 			},
 			LogWARN, true, nil,
 		},
 		{
 			func() {
 				FakeConfig(map[string]string{"log/level": "NOTICE"})
-				InitLogError = initLog()
+				InitLogError = defaultProject.initLog()
 			},
 			LogNOTICE, true, nil,
 		},
 		{
 			func() {
 				FakeConfig(map[string]string{"log/level": "INFO"})
-				InitLogError = initLog()
+				InitLogError = defaultProject.initLog()
 			},
 			LogINFO, true, nil,
 		},
 		{
 			func() {
 				FakeConfig(map[string]string{"log/level": "DEBUG"})
-				InitLogError = initLog()
+				InitLogError = defaultProject.initLog()
 			},
 			LogDEBUG, true, nil,
 		},
@@ -161,14 +177,14 @@ func TestInitLog(t *testing.T) {
 	for i, c := range cases {
 		t.Run(strconv.Itoa(i+1), func(t *testing.T) {
 			c.setup()
-			if logLevel != c.level {
-				t.Errorf("logLevel = %v, want %v", logLevel, c.level)
+			if defaultProject.getLog().level != c.level {
+				t.Errorf("log level = %v, want %v", defaultProject.getLog().level, c.level)
 			}
-			if (syslogLogger != nil) != c.ready {
+			if (defaultProject.getLog().syslog != nil) != c.ready {
 				if c.ready {
-					t.Errorf("syslogLogger = %v, want !=nil", syslogLogger)
+					t.Errorf("syslog = %v, want !=nil", defaultProject.getLog().syslog)
 				} else {
-					t.Errorf("syslogLogger = %v, want nil", syslogLogger)
+					t.Errorf("syslog = %v, want nil", defaultProject.getLog().syslog)
 				}
 			}
 			if (InitLogError == nil) != (c.wanterr == nil) || InitLogError != nil && InitLogError.Error() != c.wanterr.Error() {
@@ -242,7 +258,7 @@ func TestLog(t *testing.T) { // nolint:gocyclo
 		t.Errorf("no log\nexp: %#v\ngot: %#v", wantlines, lines)
 	}
 
-	logLevel = LogDEBUG
+	setLogLevel(LogDEBUG)
 	l.ERR("%%10")
 	l.WARN("%%20")
 	l.NOTICE("%%30")
@@ -270,22 +286,23 @@ func TestLog(t *testing.T) { // nolint:gocyclo
 		t.Errorf("all+plain\nexp: %#v\ngot: %#v", wantlines, lines)
 	}
 
-	logLevel = LogNOTICE
+	d := "d" // prevent vet from checking non-format functions
+	setLogLevel(LogNOTICE)
 	l.ERR("%%1%d", 1)
 	l.WARN("%%2%d", 1)
 	l.NOTICE("%%3%d", 1)
 	l.INFO("%%4%d", 1)
 	l.DEBUG("%%5%d", 1)
-	l.Print("%%6%"+"d", 0)
+	l.Print("%%6%"+d, 0)
 	l.Printf("%%6%d", 1)
-	l.Println("%%6%"+"d", 2)
-	if pnk := getpnk(func() { l.Panic("%%7%"+"d", 0) }); !reflect.DeepEqual(pnk, "%%7%d0") {
+	l.Println("%%6%"+d, 2)
+	if pnk := getpnk(func() { l.Panic("%%7%"+d, 0) }); !reflect.DeepEqual(pnk, "%%7%d0") {
 		t.Errorf("level+sprintf, panic=%#v, want %#v", pnk, "%%7%d0")
 	}
 	if pnk := getpnk(func() { l.Panicf("%%7%d", 1) }); !reflect.DeepEqual(pnk, "%71") {
 		t.Errorf("level+sprintf, panic=%#v, want %#v", pnk, "%71")
 	}
-	if pnk := getpnk(func() { l.Panicln("%%7%"+"d", 2) }); !reflect.DeepEqual(pnk, "%%7%d 2\n") {
+	if pnk := getpnk(func() { l.Panicln("%%7%"+d, 2) }); !reflect.DeepEqual(pnk, "%%7%d 2\n") {
 		t.Errorf("level+sprintf, panic=%#v, want %#v", pnk, "%%7%d 2\n")
 	}
 	wantlines = []string{
@@ -298,7 +315,7 @@ func TestLog(t *testing.T) { // nolint:gocyclo
 		t.Errorf("level+sprintf\nexp: %#v\ngot: %#v", wantlines, lines)
 	}
 
-	logLevel = LogWARN
+	setLogLevel(LogWARN)
 	l.Print("63")
 	if pnk := getpnk(func() { l.Panic("73") }); !reflect.DeepEqual(pnk, "73") {
 		t.Errorf("level+sprint, panic=%#v, want %#v", pnk, "73")
@@ -320,11 +337,11 @@ func TestLog(t *testing.T) { // nolint:gocyclo
 
 	buf := bytes.NewBufferString("")
 	log.SetOutput(buf)
-	origSyslogLogger := syslogLogger
-	syslogLogger = nil
+	origLog := defaultProject.getLog()
+	defaultProject.log.Store(&logState{level: origLog.level})
 	l.ERR("13")
 	l.WARN("2%d", 3)
-	syslogLogger = origSyslogLogger
+	defaultProject.log.Store(origLog)
 	wantlines = []string{}
 	lines = getLines()
 	if !reflect.DeepEqual(lines, wantlines) {
@@ -344,7 +361,7 @@ func TestLog(t *testing.T) { // nolint:gocyclo
 		t.Errorf("fallback buf=%q, want %q", buf.String(), want)
 	}
 
-	InitLogError = initLog()
+	InitLogError = defaultProject.initLog()
 }
 
 func getLines() []string {
@@ -367,3 +384,294 @@ func getpnk(f func()) (pnk interface{}) {
 	f()
 	return
 }
+
+func TestLogFile(t *testing.T) {
+	origOpen := defaultProject.open
+	defer func() {
+		defaultProject.open = origOpen
+		InitLogError = defaultProject.initLog()
+	}()
+	for _, name := range []string{"var/log.txt", "var/log.txt.1"} {
+		if err := os.Remove(name); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+	}
+	FakeConfig(map[string]string{"log/type": "file", "log/file": "var/log.txt"})
+	if err := defaultProject.initLog(); err != nil {
+		t.Fatalf("defaultProject.initLog(), err = %v", err)
+	}
+	if defaultProject.getLog().file == nil || defaultProject.getLog().syslog != nil {
+		t.Fatalf("file = %v, syslog = %v", defaultProject.getLog().file, defaultProject.getLog().syslog)
+	}
+
+	l := NewLog("pfx ")
+	l.INFO("one %d", 1)
+	l.DEBUG("skipped")
+	l.Println("two")
+	if err := os.Rename("var/log.txt", "var/log.txt.1"); err != nil {
+		t.Fatal(err)
+	}
+	l.ERR("three")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := WatchLog(ctx, nil); err != nil {
+		t.Fatalf("WatchLog(), err = %v", err)
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if _, err := os.Stat("var/log.txt"); err == nil {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	l.WARN("four\nfive")
+
+	line := `\d{4}-\d\d-\d\d \d\d:\d\d:\d\d ` + regexp.QuoteMeta(path.Base(os.Args[0])) + `\[` + strconv.Itoa(os.Getpid()) + `\]: `
+	cases := []struct {
+		file string
+		want string
+	}{
+		{"var/log.txt.1", `\A` + line + `INFO: pfx one 1\n` + line + `NOTICE: pfx two\n` + line + `ERR: pfx three\n\z`},
+		{"var/log.txt", `\A` + line + `WARN: pfx four\nfive\n\z`},
+	}
+	for _, c := range cases {
+		buf, err := ioutil.ReadFile(c.file)
+		if err != nil {
+			t.Errorf("ReadFile(%q), err = %v", c.file, err)
+		}
+		if !regexp.MustCompile(c.want).Match(buf) {
+			t.Errorf("ReadFile(%q) = %q, want match %q", c.file, buf, c.want)
+		}
+	}
+}
+
+func TestFileLogClose(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "log.txt")
+	l, err := openFileLog(name)
+	if err != nil {
+		t.Fatalf("openFileLog(), err = %v", err)
+	}
+	if err = l.Close(); err != nil {
+		t.Errorf("Close(), err = %v", err)
+	}
+	if err = l.reopen(); err != nil {
+		t.Errorf("reopen() after Close(), err = %v", err)
+	}
+	fds, err := ioutil.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, fd := range fds {
+		if link, _ := os.Readlink(filepath.Join("/proc/self/fd", fd.Name())); link == name {
+			t.Errorf("fd %s is open for %s after Close()", fd.Name(), name)
+		}
+	}
+}
+
+func TestLogWith(t *testing.T) {
+	origLog := defaultProject.getLog()
+	defer defaultProject.log.Store(origLog)
+	defaultProject.log.Store(&logState{level: LogINFO})
+	buf := bytes.NewBufferString("")
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	l := NewLog("pfx: ")
+	l2 := l.With("req", 42, "user", "John Doe")
+	l3 := l2.With("dur", time.Second)
+	l.ERRw("plain")
+	l.WARNw("kv", "a", 1)
+	l2.NOTICEw("more", "quote", `"x"`, "nl", "a\nb")
+	l3.INFOw("odd", "key")
+	l3.DEBUGw("skipped", "a", 1)
+	l3.ERR("format %d", 1)
+	l2.Println("line")
+	if l2.Prefix() != "pfx: " {
+		t.Errorf("l2.Prefix() = %q, want %q", l2.Prefix(), "pfx: ")
+	}
+	want := `ERR: pfx: plain
+WARN: pfx: kv a=1
+NOTICE: pfx: more req=42 user="John Doe" quote="\"x\"" nl="a\nb"
+INFO: pfx: odd req=42 user="John Doe" dur=1s key=(MISSING)
+ERR: pfx: format 1 req=42 user="John Doe" dur=1s
+NOTICE: pfx: line req=42 user="John Doe"
+`
+	if buf.String() != want {
+		t.Errorf("buf = %q, want %q", buf.String(), want)
+	}
+}
+
+func setLogLevel(level LogLevel) {
+	l := *defaultProject.getLog()
+	l.level = level
+	defaultProject.log.Store(&l)
+}
+
+func TestReloadLog(t *testing.T) {
+	origOpen := defaultProject.open
+	defer func() {
+		defaultProject.open = origOpen
+		InitLogError = defaultProject.initLog()
+	}()
+	FakeConfig(map[string]string{"log/type": "file", "log/file": "var/reload.txt", "log/level": "WARN"})
+	if err := ReloadLog(); err != nil {
+		t.Fatalf("ReloadLog(), err = %v", err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		l := NewLog("")
+		for i := 0; i < 100; i++ {
+			l.ERR("%d", i)
+		}
+	}()
+	FakeConfig(map[string]string{"log/type": "file", "log/file": "var/reload.txt", "log/level": "ERR"})
+	if err := ReloadLog(); err != nil {
+		t.Errorf("ReloadLog(), err = %v", err)
+	}
+	<-done
+	if defaultProject.getLog().level != LogERR || defaultProject.getLog().file == nil {
+		t.Errorf("log level = %v, file = %v, want %v and !=nil", defaultProject.getLog().level, defaultProject.getLog().file, LogERR)
+	}
+
+	FakeConfig(map[string]string{"log/type": "file", "log/file": "var/reload.txt", "log/level": "bad"})
+	err := ReloadLog()
+	wanterr := "unsupported config/log/level: bad"
+	if err == nil || err.Error() != wanterr {
+		t.Errorf("ReloadLog(), err = %v, want %v", err, wanterr)
+	}
+	if defaultProject.getLog().level != LogERR || defaultProject.getLog().file == nil {
+		t.Errorf("log level = %v, file = %v, want kept %v and !=nil", defaultProject.getLog().level, defaultProject.getLog().file, LogERR)
+	}
+}
+
+func TestReloadLogRace(t *testing.T) {
+	origOpen := defaultProject.open
+	defer func() {
+		defaultProject.open = origOpen
+		InitLogError = defaultProject.initLog()
+	}()
+	if err := os.Remove("var/race.txt"); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	FakeConfig(map[string]string{"log/type": "file", "log/file": "var/race.txt", "log/level": "INFO"})
+	if err := ReloadLog(); err != nil {
+		t.Fatalf("ReloadLog(), err = %v", err)
+	}
+	buf := new(bytes.Buffer)
+	log.SetOutput(buf)
+	defer log.SetOutput(os.Stderr)
+
+	const writers, lines = 4, 1000
+	var wg sync.WaitGroup
+	for i := 0; i < writers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l := NewLog("")
+			for j := 0; j < lines; j++ {
+				l.INFO("%d", j)
+			}
+		}()
+	}
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	for reloading := true; reloading; {
+		select {
+		case <-done:
+			reloading = false
+		default:
+			if err := ReloadLog(); err != nil {
+				t.Fatalf("ReloadLog(), err = %v", err)
+			}
+		}
+	}
+
+	// Replaced log must not be closed while write to it is in progress.
+	l := defaultProject.acquireLog()
+	reloaded := make(chan error, 1)
+	go func() { reloaded <- ReloadLog() }()
+	time.Sleep(tick)
+	if err := l.file.write(LogINFO, "in-flight"); err != nil {
+		t.Errorf("write(), err = %v", err)
+	}
+	l.mu.RUnlock()
+	if err := <-reloaded; err != nil {
+		t.Errorf("ReloadLog(), err = %v", err)
+	}
+
+	if buf.Len() != 0 {
+		t.Errorf("fallback output = %q, want nothing", buf.String())
+	}
+	data, err := ioutil.ReadFile("var/race.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := bytes.Count(data, []byte("\n")); n != writers*lines+1 {
+		t.Errorf("logged %d lines, want %d", n, writers*lines+1)
+	}
+}
+
+func TestWatchLog(t *testing.T) {
+	origOpen := defaultProject.open
+	defer func() {
+		defaultProject.open = origOpen
+		InitLogError = defaultProject.initLog()
+	}()
+	FakeConfig(map[string]string{"log/type": "file", "log/file": "var/watch.txt", "log/level": "ERR"})
+	if err := ReloadLog(); err != nil {
+		t.Fatalf("ReloadLog(), err = %v", err)
+	}
+	FakeConfig(map[string]string{"log/type": "file", "log/file": "var/watch.txt"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errc := make(chan error, 8)
+	if err := WatchLog(ctx, func(err error) { errc <- err }); err != nil {
+		t.Fatalf("WatchLog(), err = %v", err)
+	}
+
+	waitLevel := func(want LogLevel) {
+		t.Helper()
+		for i := 0; i < 100 && defaultProject.getLog().level != want; i++ {
+			time.Sleep(10 * time.Millisecond)
+		}
+		if defaultProject.getLog().level != want {
+			t.Errorf("log level = %v, want %v", defaultProject.getLog().level, want)
+		}
+	}
+	if err := syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatal(err)
+	}
+	waitLevel(LogINFO)
+
+	if err := ioutil.WriteFile("config/log/level", []byte("WARN\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitLevel(LogWARN)
+
+	if err := ioutil.WriteFile("config/log/level", []byte("bad\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errc:
+		if wanterr := "unsupported config/log/level: bad"; err.Error() != wanterr {
+			t.Errorf("onError(%v), want %v", err, wanterr)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("onError() not called")
+	}
+	if defaultProject.getLog().level != LogWARN {
+		t.Errorf("log level = %v, want kept %v", defaultProject.getLog().level, LogWARN)
+	}
+
+	if err := ioutil.WriteFile("config/log/level", []byte("INFO\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	waitLevel(LogINFO)
+	cancel()
+	time.Sleep(2 * logReloadDelay)
+}
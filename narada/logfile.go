@@ -0,0 +1,78 @@
+package narada
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const logTimeFormat = "2006-01-02 15:04:05"
+
+// fileLog appends log lines to a file. It's reopened by WatchLog on
+// SIGHUP to support logrotate.
+type fileLog struct {
+	name   string
+	tag    string
+	mu     sync.Mutex
+	f      *os.File
+	closed bool
+}
+
+func openFileLog(name string) (*fileLog, error) {
+	l := &fileLog{
+		name: name,
+		tag:  path.Base(os.Args[0]) + "[" + strconv.Itoa(os.Getpid()) + "]",
+	}
+	if err := l.reopen(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// reopen does nothing if l is closed.
+func (l *fileLog) reopen() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.closed {
+		return nil
+	}
+	f, err := os.OpenFile(l.name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	old := l.f
+	l.f = f
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// write append line in a single write(2) call, so lines from
+// different processes won't be mixed.
+func (l *fileLog) write(level LogLevel, msg string) error {
+	var b strings.Builder
+	b.WriteString(time.Now().Format(logTimeFormat))
+	b.WriteByte(' ')
+	b.WriteString(l.tag)
+	b.WriteString(": ")
+	b.WriteString(level.String())
+	b.WriteString(": ")
+	b.WriteString(strings.TrimRight(msg, "\n"))
+	b.WriteByte('\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	_, err := l.f.WriteString(b.String())
+	return err
+}
+
+func (l *fileLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.closed = true
+	return l.f.Close()
+}
@@ -0,0 +1,75 @@
+package narada
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const logfmtMissing = "(MISSING)"
+
+// appendFields returns fields with keyvals appended in logfmt.
+// Odd keyvals will get value "(MISSING)".
+func appendFields(fields string, keyvals []interface{}) string {
+	if len(keyvals) == 0 {
+		return fields
+	}
+	var b strings.Builder
+	b.WriteString(fields)
+	for i := 0; i < len(keyvals); i += 2 {
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(logfmtKey(fmt.Sprint(keyvals[i])))
+		b.WriteByte('=')
+		if i+1 < len(keyvals) {
+			b.WriteString(logfmtValue(keyvals[i+1]))
+		} else {
+			b.WriteString(logfmtMissing)
+		}
+	}
+	return b.String()
+}
+
+// logfmtKey replaces all chars not allowed in logfmt key with '_'.
+func logfmtKey(key string) string {
+	if key == "" {
+		return "_"
+	}
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError || !unicode.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+func logfmtValue(v interface{}) string {
+	var s string
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case string:
+		s = v
+	default:
+		s = fmt.Sprint(v)
+	}
+	if needsQuote(s) {
+		return strconv.Quote(s)
+	}
+	return s
+}
+
+func needsQuote(s string) bool {
+	if s == "" {
+		return true
+	}
+	for _, r := range s {
+		if r <= ' ' || r == '=' || r == '"' || r == '\\' || r == utf8.RuneError || !unicode.IsPrint(r) {
+			return true
+		}
+	}
+	return false
+}
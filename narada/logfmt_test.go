@@ -0,0 +1,44 @@
+package narada
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestAppendFields(t *testing.T) {
+	var nilErr *stringerErr
+	cases := []struct {
+		fields  string
+		keyvals []interface{}
+		want    string
+	}{
+		{"", nil, ""},
+		{"a=1", nil, "a=1"},
+		{"", []interface{}{"a", 1}, "a=1"},
+		{"a=1", []interface{}{"b", "two", "c", 3.5}, "a=1 b=two c=3.5"},
+		{"", []interface{}{"a"}, "a=(MISSING)"},
+		{"", []interface{}{"a", nil}, "a=null"},
+		{"", []interface{}{"a", ""}, `a=""`},
+		{"", []interface{}{"a", "x y"}, `a="x y"`},
+		{"", []interface{}{"a", `say "hi"`}, `a="say \"hi\""`},
+		{"", []interface{}{"a", "x\ny"}, `a="x\ny"`},
+		{"", []interface{}{"a", "x=y"}, `a="x=y"`},
+		{"", []interface{}{"a", `x\y`}, `a="x\\y"`},
+		{"", []interface{}{"a", "ёж"}, "a=ёж"},
+		{"", []interface{}{"a", errors.New("some error")}, `a="some error"`},
+		{"", []interface{}{"a", 3 * time.Second}, "a=3s"},
+		{"", []interface{}{"a", nilErr}, "a=<nil>"},
+		{"", []interface{}{"", 1, "a b", 2, `a="b"`, 3, 4, 5}, `_=1 a_b=2 a__b_=3 4=5`},
+	}
+	for _, c := range cases {
+		got := appendFields(c.fields, c.keyvals)
+		if got != c.want {
+			t.Errorf("appendFields(%q, %#v) = %q, want %q", c.fields, c.keyvals, got, c.want)
+		}
+	}
+}
+
+type stringerErr struct{ msg string }
+
+func (e *stringerErr) Error() string { return e.msg }
@@ -0,0 +1,357 @@
+// Package migrate upgrades and downgrades Narada project data (database
+// schema, files in var/, etc.) using ordered migration steps.
+//
+// Each step has a version and changes project from previous step's
+// version to own version (Up) or back (Down). Steps are either Go
+// functions or executable files in migrations directory:
+//
+//	migrations/1.1.0.up
+//	migrations/1.1.0.down
+//
+// Versions of applied steps are recorded in file var/migrate/applied
+// (one version per line, in order they was applied) and VERSION file is
+// updated after each step. Migrator refuses to run if last applied
+// version doesn't match VERSION. If var/migrate/applied doesn't exist
+// then all steps with version up to VERSION are considered applied.
+//
+// All steps are run under narada.ExclusiveLock. Executable files are
+// run from project directory with $NARADA_DIR and $NARADA_SKIP_LOCK set.
+// Go functions get ctx which carries this lock (see
+// narada.WithExclusiveLock), so they should pass it to narada functions
+// accepting ctx (like narada.GetConfigContext or
+// narada.ReadConfigSnapshotContext) - functions which get narada lock
+// without ctx (like narada.GetConfig) will deadlock.
+package migrate
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/powerman/narada-go/narada"
+	"github.com/powerman/narada-go/narada/internal/atomicfile"
+)
+
+const (
+	versionFile = "VERSION"
+	stateFile   = "var/migrate/applied"
+)
+
+// Errors.
+var (
+	ErrVersionMismatch = errors.New("VERSION doesn't match applied migrations")
+	ErrUnknownVersion  = errors.New("unknown migration version")
+	ErrIrreversible    = errors.New("migration is irreversible")
+	ErrDuplicate       = errors.New("duplicate migration version")
+)
+
+// Step is a single migration step.
+type Step struct {
+	Version string
+	Up      func(ctx context.Context) error
+	Down    func(ctx context.Context) error // Nil if step is irreversible.
+}
+
+// Migrator runs migration steps.
+type Migrator struct {
+	// Dir (relative to project root) contains migration files named
+	// "VERSION.up" and "VERSION.down". It's ignored if empty or doesn't
+	// exist.
+	Dir string
+	// Project to migrate, nil means default project.
+	Project *narada.Project
+	steps   []Step
+}
+
+// New returns Migrator with given Go steps and files from "migrations"
+// directory.
+func New(steps ...Step) *Migrator {
+	return &Migrator{Dir: "migrations", steps: steps}
+}
+
+func (m *Migrator) project() *narada.Project {
+	if m.Project == nil {
+		return narada.DefaultProject()
+	}
+	return m.Project
+}
+
+// Steps returns all known steps (both Go functions and files) sorted by
+// version.
+func (m *Migrator) Steps() ([]Step, error) {
+	p := m.project()
+	steps := make(map[string]*Step)
+	source := make(map[string]string)
+	for i := range m.steps {
+		step := m.steps[i]
+		if _, err := narada.ParseProjectVersion(step.Version); err != nil {
+			return nil, err
+		}
+		if _, ok := steps[step.Version]; ok {
+			return nil, fmt.Errorf("%w: %s", ErrDuplicate, step.Version)
+		}
+		steps[step.Version] = &step
+		source[step.Version] = "go"
+	}
+
+	if m.Dir != "" {
+		fis, err := ioutil.ReadDir(p.Path(m.Dir))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		for _, fi := range fis {
+			ext := filepath.Ext(fi.Name())
+			if fi.IsDir() || (ext != ".up" && ext != ".down") {
+				continue
+			}
+			version := strings.TrimSuffix(fi.Name(), ext)
+			if _, err := narada.ParseProjectVersion(version); err != nil {
+				return nil, err
+			}
+			if source[version] == "go" {
+				return nil, fmt.Errorf("%w: %s", ErrDuplicate, version)
+			}
+			step, ok := steps[version]
+			if !ok {
+				step = &Step{Version: version}
+				steps[version] = step
+				source[version] = "file"
+			}
+			run := runFile(p.Root(), p.Path(filepath.Join(m.Dir, fi.Name())))
+			if ext == ".up" {
+				step.Up = run
+			} else {
+				step.Down = run
+			}
+		}
+	}
+
+	list := make([]Step, 0, len(steps))
+	for _, step := range steps {
+		if step.Up == nil {
+			return nil, fmt.Errorf("migration %s has no up step", step.Version)
+		}
+		list = append(list, *step)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return compareVersions(list[i].Version, list[j].Version) < 0
+	})
+	return list, nil
+}
+
+// Applied returns versions of applied steps.
+func (m *Migrator) Applied() ([]string, error) {
+	return m.AppliedContext(context.Background())
+}
+
+// AppliedContext works like Applied but gets shared lock using
+// narada.SharedLockContext(ctx), so it may be called from a step (or
+// while ctx carries lock, see narada.WithExclusiveLock).
+func (m *Migrator) AppliedContext(ctx context.Context) ([]string, error) {
+	p := m.project()
+	lock, err := p.SharedLockContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer lock.UnLock()
+	steps, err := m.Steps()
+	if err != nil {
+		return nil, err
+	}
+	st, err := loadState(p, steps)
+	if err != nil {
+		return nil, err
+	}
+	return st.applied, nil
+}
+
+// Up applies all not applied steps with version up to target (all
+// steps if target is empty).
+func (m *Migrator) Up(ctx context.Context, target string) error {
+	return m.run(ctx, target, true)
+}
+
+// Down reverts all applied steps with version greater than target.
+// VERSION will be set to version of last still applied step or to
+// target if there are no such steps.
+func (m *Migrator) Down(ctx context.Context, target string) error {
+	return m.run(ctx, target, false)
+}
+
+func (m *Migrator) run(ctx context.Context, target string, up bool) error {
+	steps, err := m.Steps()
+	if err != nil {
+		return err
+	}
+	if up && target == "" {
+		if len(steps) == 0 {
+			return nil
+		}
+		target = steps[len(steps)-1].Version
+	}
+	if _, err = narada.ParseProjectVersion(target); err != nil {
+		return err
+	}
+
+	p := m.project()
+	return p.WithExclusiveLock(ctx, func(ctx context.Context) error {
+		st, err := loadState(p, steps)
+		if err != nil {
+			return err
+		}
+		if up {
+			return st.up(ctx, steps, target)
+		}
+		return st.down(ctx, steps, target)
+	})
+}
+
+type state struct {
+	p       *narada.Project
+	version string // With build metadata, like "1.2.3+name-timestamp".
+	applied []string
+}
+
+func loadState(p *narada.Project, steps []Step) (*state, error) {
+	buf, err := ioutil.ReadFile(p.Path(versionFile))
+	if err != nil {
+		return nil, err
+	}
+	st := &state{p: p, version: string(bytes.TrimSpace(buf))}
+	if _, err = narada.ParseProjectVersion(st.version); err != nil {
+		return nil, err
+	}
+
+	buf, err = ioutil.ReadFile(p.Path(stateFile))
+	switch {
+	case os.IsNotExist(err):
+		for _, step := range steps {
+			if compareVersions(step.Version, st.version) <= 0 {
+				st.applied = append(st.applied, step.Version)
+			}
+		}
+		return st, nil
+	case err != nil:
+		return nil, err
+	}
+	st.applied = strings.Fields(string(buf))
+	if n := len(st.applied); n > 0 && compareVersions(st.applied[n-1], st.version) != 0 {
+		return nil, fmt.Errorf("%w: VERSION is %s, last applied is %s",
+			ErrVersionMismatch, st.version, st.applied[n-1])
+	}
+	return st, nil
+}
+
+func (st *state) isApplied(version string) bool {
+	for _, v := range st.applied {
+		if v == version {
+			return true
+		}
+	}
+	return false
+}
+
+func (st *state) up(ctx context.Context, steps []Step, target string) error {
+	if compareVersions(target, st.version) < 0 {
+		return fmt.Errorf("target version %s is older than VERSION %s", target, st.version)
+	}
+	for _, step := range steps {
+		if compareVersions(step.Version, target) > 0 {
+			break
+		}
+		if st.isApplied(step.Version) {
+			continue
+		}
+		if err := step.Up(ctx); err != nil {
+			return fmt.Errorf("migration %s up: %w", step.Version, err)
+		}
+		st.applied = append(st.applied, step.Version)
+		if err := st.save(step.Version); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (st *state) down(ctx context.Context, steps []Step, target string) error {
+	if compareVersions(target, st.version) > 0 {
+		return fmt.Errorf("target version %s is newer than VERSION %s", target, st.version)
+	}
+	byVersion := make(map[string]Step, len(steps))
+	for _, step := range steps {
+		byVersion[step.Version] = step
+	}
+	for i := len(st.applied) - 1; i >= 0; i-- {
+		version := st.applied[i]
+		if compareVersions(version, target) <= 0 {
+			break
+		}
+		step, ok := byVersion[version]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownVersion, version)
+		}
+		if step.Down == nil {
+			return fmt.Errorf("%w: %s", ErrIrreversible, version)
+		}
+		if err := step.Down(ctx); err != nil {
+			return fmt.Errorf("migration %s down: %w", version, err)
+		}
+		st.applied = st.applied[:i]
+		current := target
+		if i > 0 {
+			current = st.applied[i-1]
+		}
+		if err := st.save(current); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// save writes applied steps first, so on failure to update VERSION next
+// run will detect mismatch. Build metadata of current VERSION is kept
+// unless version has own one.
+func (st *state) save(version string) error {
+	var b strings.Builder
+	for _, v := range st.applied {
+		b.WriteString(v)
+		b.WriteByte('\n')
+	}
+	if err := atomicfile.WriteFile(st.p.Path(stateFile), []byte(b.String()), 0644); err != nil {
+		return err
+	}
+	cur, _ := narada.ParseProjectVersion(st.version)
+	if cur.Build != "" && !strings.Contains(version, "+") {
+		version += "+" + cur.Build
+	}
+	if err := atomicfile.WriteFile(st.p.Path(versionFile), []byte(version+"\n"), 0644); err != nil {
+		return err
+	}
+	st.version = version
+	return nil
+}
+
+func runFile(root, name string) func(ctx context.Context) error {
+	return func(ctx context.Context) error {
+		cmd := exec.CommandContext(ctx, name)
+		cmd.Dir = root
+		cmd.Env = append(os.Environ(), "NARADA_DIR="+root, "NARADA_SKIP_LOCK=1")
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+}
+
+// compareVersions compares already validated versions.
+func compareVersions(a, b string) int {
+	v, _ := narada.ParseProjectVersion(a)
+	w, _ := narada.ParseProjectVersion(b)
+	return v.Compare(w)
+}
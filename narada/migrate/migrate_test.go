@@ -0,0 +1,239 @@
+package migrate
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/powerman/narada-go/narada/internal/testutil"
+	"github.com/powerman/narada-go/narada/staging"
+)
+
+func setUp(t *testing.T, version string) {
+	t.Helper()
+	if err := ioutil.WriteFile(versionFile, []byte(version+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{stateFile, "migrations", "var/migrate/log"} {
+		if err := os.RemoveAll(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func writeScript(t *testing.T, name, script string) {
+	t.Helper()
+	if err := os.MkdirAll("migrations", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile("migrations/"+name, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// step returns Step which appends "+version" and "-version" to log.
+func step(version string, log *[]string) Step {
+	return Step{
+		Version: version,
+		Up:      func(context.Context) error { *log = append(*log, "+"+version); return nil },
+		Down:    func(context.Context) error { *log = append(*log, "-"+version); return nil },
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	setUp(t, "1.0.0+example-1234567890")
+	writeScript(t, "1.2.0.up", "echo +1.2.0 >>var/migrate/log; env | grep -q NARADA_SKIP_LOCK=1\n")
+	writeScript(t, "1.2.0.down", "echo -1.2.0 >>var/migrate/log\n")
+	var log []string
+	m := New(step("0.9.0", &log), step("1.0.0", &log), step("1.1.0", &log), step("1.3.0", &log))
+
+	steps, err := m.Steps()
+	if err != nil {
+		t.Fatalf("Steps(), err = %v", err)
+	}
+	var versions []string
+	for _, s := range steps {
+		versions = append(versions, s.Version)
+	}
+	if want := []string{"0.9.0", "1.0.0", "1.1.0", "1.2.0", "1.3.0"}; !reflect.DeepEqual(versions, want) {
+		t.Errorf("Steps() = %v, want %v", versions, want)
+	}
+
+	applied, err := m.Applied()
+	if want := []string{"0.9.0", "1.0.0"}; err != nil || !reflect.DeepEqual(applied, want) {
+		t.Errorf("Applied() = %v, %v, want %v", applied, err, want)
+	}
+
+	if err = m.Up(ctx, "1.2.0"); err != nil {
+		t.Fatalf("Up(1.2.0), err = %v", err)
+	}
+	if want := []string{"+1.1.0"}; !reflect.DeepEqual(log, want) {
+		t.Errorf("log = %v, want %v", log, want)
+	}
+	if s, want := testutil.ReadFile(t, "var/migrate/log"), "+1.2.0\n"; s != want {
+		t.Errorf("var/migrate/log = %q, want %q", s, want)
+	}
+	if s, want := testutil.ReadFile(t, versionFile), "1.2.0+example-1234567890\n"; s != want {
+		t.Errorf("VERSION = %q, want %q", s, want)
+	}
+	if s, want := testutil.ReadFile(t, stateFile), "0.9.0\n1.0.0\n1.1.0\n1.2.0\n"; s != want {
+		t.Errorf("%s = %q, want %q", stateFile, s, want)
+	}
+
+	log = nil
+	if err = m.Up(ctx, ""); err != nil {
+		t.Fatalf("Up(), err = %v", err)
+	}
+	if err = m.Up(ctx, ""); err != nil {
+		t.Fatalf("Up(), err = %v", err)
+	}
+	if want := []string{"+1.3.0"}; !reflect.DeepEqual(log, want) {
+		t.Errorf("log = %v, want %v", log, want)
+	}
+	if err = m.Up(ctx, "1.2.0"); err == nil {
+		t.Errorf("Up(1.2.0), err = nil")
+	}
+
+	log = nil
+	if err = m.Down(ctx, "1.0.5"); err != nil {
+		t.Fatalf("Down(1.0.5), err = %v", err)
+	}
+	if want := []string{"-1.3.0", "-1.1.0"}; !reflect.DeepEqual(log, want) {
+		t.Errorf("log = %v, want %v", log, want)
+	}
+	if s, want := testutil.ReadFile(t, "var/migrate/log"), "+1.2.0\n-1.2.0\n"; s != want {
+		t.Errorf("var/migrate/log = %q, want %q", s, want)
+	}
+	if s, want := testutil.ReadFile(t, versionFile), "1.0.0+example-1234567890\n"; s != want {
+		t.Errorf("VERSION = %q, want %q", s, want)
+	}
+
+	log = nil
+	if err = m.Down(ctx, "0.1"); err != nil {
+		t.Fatalf("Down(0.1), err = %v", err)
+	}
+	if want := []string{"-1.0.0", "-0.9.0"}; !reflect.DeepEqual(log, want) {
+		t.Errorf("log = %v, want %v", log, want)
+	}
+	if s, want := testutil.ReadFile(t, versionFile), "0.1+example-1234567890\n"; s != want {
+		t.Errorf("VERSION = %q, want %q", s, want)
+	}
+	applied, err = m.Applied()
+	if err != nil || len(applied) != 0 {
+		t.Errorf("Applied() = %v, %v, want []", applied, err)
+	}
+}
+
+func TestMigrateProject(t *testing.T) {
+	p := staging.New(t, staging.Txtar(`
+-- VERSION --
+1.0.0
+-- config/migrate/value --
+42
+-- migrations/1.2.0.up --
+#!/bin/sh
+echo "$NARADA_DIR" >var/migrate/dir
+`), func(dir string) error { return os.Chmod(dir+"/migrations/1.2.0.up", 0755) })
+
+	var value []byte
+	var applied []string
+	var m *Migrator
+	m = New(Step{Version: "1.1.0", Up: func(ctx context.Context) (err error) {
+		if value, err = p.GetConfigContext(ctx, "migrate/value"); err != nil {
+			return err
+		}
+		if applied, err = m.AppliedContext(ctx); err != nil {
+			return err
+		}
+		_, err = p.VersionContext(ctx)
+		return err
+	}})
+	m.Project = p
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := m.Up(ctx, ""); err != nil {
+		t.Fatalf("Up(), err = %v", err)
+	}
+	if string(value) != "42\n" {
+		t.Errorf("GetConfigContext() = %q, want %q", value, "42\n")
+	}
+	if len(applied) != 0 {
+		t.Errorf("AppliedContext() = %q, want none", applied)
+	}
+	if s, want := testutil.ReadFile(t, p.Path("var/migrate/dir")), p.Root()+"\n"; s != want {
+		t.Errorf("$NARADA_DIR = %q, want %q", s, want)
+	}
+	if s, want := testutil.ReadFile(t, p.Path(versionFile)), "1.2.0\n"; s != want {
+		t.Errorf("VERSION = %q, want %q", s, want)
+	}
+	if s := testutil.ReadFile(t, stateFile); strings.Contains(s, "1.1.0") {
+		t.Errorf("default project %s = %q, want unchanged", stateFile, s)
+	}
+}
+
+func TestMigrateErrors(t *testing.T) {
+	ctx := context.Background()
+	var log []string
+	failed := errors.New("failed")
+
+	setUp(t, "1.0.0")
+	if err := os.MkdirAll("var/migrate", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(stateFile, []byte("0.9.0\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	m := New(step("0.9.0", &log), step("1.0.0", &log))
+	if err := m.Up(ctx, ""); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Up(), err = %v, want %v", err, ErrVersionMismatch)
+	}
+	if err := m.Down(ctx, "0.1"); !errors.Is(err, ErrVersionMismatch) {
+		t.Errorf("Down(), err = %v, want %v", err, ErrVersionMismatch)
+	}
+
+	setUp(t, "1.0.0")
+	writeScript(t, "1.0.0.up", "exit 0\n")
+	if _, err := m.Steps(); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Steps(), err = %v, want %v", err, ErrDuplicate)
+	}
+	if _, err := New(step("1.0", &log), step("1.0", &log)).Steps(); !errors.Is(err, ErrDuplicate) {
+		t.Errorf("Steps(), err = %v, want %v", err, ErrDuplicate)
+	}
+	if _, err := New(step("v1", &log)).Steps(); err == nil {
+		t.Errorf("Steps(), err = nil")
+	}
+
+	setUp(t, "1.0.0")
+	writeScript(t, "1.1.0.down", "exit 0\n")
+	if _, err := New().Steps(); err == nil || !strings.Contains(err.Error(), "no up step") {
+		t.Errorf("Steps(), err = %v", err)
+	}
+
+	setUp(t, "1.0.0")
+	writeScript(t, "1.1.0.up", "exit 1\n")
+	m = New(Step{Version: "1.0.5", Up: func(context.Context) error { return nil }})
+	if err := m.Up(ctx, ""); err == nil {
+		t.Errorf("Up(), err = nil")
+	}
+	if s, want := testutil.ReadFile(t, versionFile), "1.0.5\n"; s != want {
+		t.Errorf("VERSION = %q, want %q", s, want)
+	}
+	if err := m.Down(ctx, "1.0.0"); !errors.Is(err, ErrIrreversible) {
+		t.Errorf("Down(), err = %v, want %v", err, ErrIrreversible)
+	}
+
+	setUp(t, "1.0.0")
+	m = New(Step{Version: "1.1.0", Up: func(context.Context) error { return failed }})
+	if err := m.Up(ctx, ""); !errors.Is(err, failed) {
+		t.Errorf("Up(), err = %v, want %v", err, failed)
+	}
+	if s, want := testutil.ReadFile(t, versionFile), "1.0.0\n"; s != want {
+		t.Errorf("VERSION = %q, want %q", s, want)
+	}
+}
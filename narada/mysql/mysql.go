@@ -0,0 +1,148 @@
+// Package mysql provides MySQL connection configured using Narada
+// config files config/mysql/*.
+//
+// It doesn't import any MySQL driver, so your main package should
+// import one, for example:
+//
+//	import _ "github.com/go-sql-driver/mysql"
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/powerman/narada-go/narada"
+)
+
+// DriverName is used to open database. Change it if you use non-default
+// driver name (or for tests).
+var DriverName = "mysql"
+
+// Config contains MySQL connection settings.
+type Config struct {
+	Host            string        `narada:"host"`
+	Port            int           `narada:"port" min:"1" max:"65535" default:"3306"`
+	Socket          string        `narada:"socket" default:"/var/run/mysqld/mysqld.sock"`
+	DB              string        `narada:"db"`
+	Login           string        `narada:"login"`
+	Pass            string        `narada:"pass"`
+	Charset         string        `narada:"charset" default:"utf8mb4"`
+	Timeout         time.Duration `narada:"timeout" min:"0s"`
+	ReadTimeout     time.Duration `narada:"read_timeout" min:"0s"`
+	WriteTimeout    time.Duration `narada:"write_timeout" min:"0s"`
+	MaxOpenConns    int           `narada:"max_open_conns" min:"0"`
+	MaxIdleConns    int           `narada:"max_idle_conns" min:"0" default:"2"`
+	ConnMaxLifetime time.Duration `narada:"conn_max_lifetime" min:"0s"`
+}
+
+// LoadConfig returns Config read from config/mysql/*.
+//
+// Supported files are:
+//
+//	host              - empty means connect using unix socket
+//	port              - 3306 by default
+//	socket            - /var/run/mysqld/mysqld.sock by default
+//	db
+//	login
+//	pass
+//	charset           - utf8mb4 by default
+//	timeout           - connect timeout (duration), driver's default if empty
+//	read_timeout      - duration, driver's default if empty
+//	write_timeout     - duration, driver's default if empty
+//	max_open_conns    - 0 (unlimited) by default
+//	max_idle_conns    - 2 by default
+//	conn_max_lifetime - duration, 0 (unlimited) by default
+func LoadConfig() (*Config, error) { return LoadProjectConfig(context.Background(), nil) }
+
+// LoadProjectConfig works like LoadConfig but reads config of project p
+// (nil means default project) using ctx, so it may be called while ctx
+// carries narada lock (see narada.ReadConfigSnapshotContext).
+func LoadProjectConfig(ctx context.Context, p *narada.Project) (*Config, error) {
+	if p == nil {
+		p = narada.DefaultProject()
+	}
+	snap, err := p.ReadConfigSnapshotContext(ctx, "mysql")
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err = snap.LoadConfig(&cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// DSN returns data source name in format used by
+// github.com/go-sql-driver/mysql:
+//
+//	[login[:pass]@]tcp(host:port)/db?param=value
+//	[login[:pass]@]tcp([ipv6]:port)/db?param=value
+//	[login[:pass]@]unix(socket)/db?param=value
+func (c *Config) DSN() string {
+	var b strings.Builder
+	if c.Login != "" || c.Pass != "" {
+		b.WriteString(c.Login)
+		if c.Pass != "" {
+			b.WriteByte(':')
+			b.WriteString(c.Pass)
+		}
+		b.WriteByte('@')
+	}
+	if c.Host == "" {
+		b.WriteString("unix(" + c.Socket + ")")
+	} else {
+		b.WriteString("tcp(" + net.JoinHostPort(c.Host, strconv.Itoa(c.Port)) + ")")
+	}
+	b.WriteByte('/')
+	b.WriteString(c.DB)
+
+	params := url.Values{}
+	if c.Charset != "" {
+		params.Set("charset", c.Charset)
+	}
+	if c.Timeout != 0 {
+		params.Set("timeout", c.Timeout.String())
+	}
+	if c.ReadTimeout != 0 {
+		params.Set("readTimeout", c.ReadTimeout.String())
+	}
+	if c.WriteTimeout != 0 {
+		params.Set("writeTimeout", c.WriteTimeout.String())
+	}
+	if len(params) != 0 {
+		b.WriteByte('?')
+		b.WriteString(params.Encode())
+	}
+	return b.String()
+}
+
+// Open returns *sql.DB configured using c (it doesn't connect to
+// database, use db.Ping to check connection).
+func (c *Config) Open() (*sql.DB, error) {
+	db, err := sql.Open(DriverName, c.DSN())
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(c.MaxOpenConns)
+	db.SetMaxIdleConns(c.MaxIdleConns)
+	db.SetConnMaxLifetime(c.ConnMaxLifetime)
+	return db, nil
+}
+
+// Open returns *sql.DB configured using config/mysql/* (see LoadConfig).
+func Open() (*sql.DB, error) { return OpenProject(context.Background(), nil) }
+
+// OpenProject works like Open but uses config of project p (nil means
+// default project), see LoadProjectConfig.
+func OpenProject(ctx context.Context, p *narada.Project) (*sql.DB, error) {
+	cfg, err := LoadProjectConfig(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	return cfg.Open()
+}
@@ -0,0 +1,117 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/powerman/narada-go/narada"
+	"github.com/powerman/narada-go/narada/staging"
+)
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig(), err = %v", err)
+	}
+	want := &Config{
+		Port:         3306,
+		Socket:       "/var/run/mysqld/mysqld.sock",
+		Charset:      "utf8mb4",
+		MaxIdleConns: 2,
+	}
+	if !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadConfig() = %#v, want %#v", cfg, want)
+	}
+
+	p := staging.New(t, staging.Txtar(`
+-- config/mysql/port --
+0
+`))
+	_, err = LoadProjectConfig(context.Background(), p)
+	if !errors.Is(err, narada.ErrConfigRange) {
+		t.Errorf("LoadProjectConfig(), err = %v, want %v", err, narada.ErrConfigRange)
+	}
+}
+
+func TestLoadProjectConfig(t *testing.T) {
+	p := staging.New(t, staging.Txtar(`
+-- config/mysql/host --
+db.local
+-- config/mysql/db --
+other
+`))
+	var cfg *Config
+	err := p.WithExclusiveLock(context.Background(), func(ctx context.Context) (err error) {
+		cfg, err = LoadProjectConfig(ctx, p)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("LoadProjectConfig(), err = %v", err)
+	}
+	if cfg.Host != "db.local" || cfg.DB != "other" {
+		t.Errorf("LoadProjectConfig() = %#v, want Host and DB from project", cfg)
+	}
+}
+
+func TestDSN(t *testing.T) {
+	cases := []struct {
+		cfg  Config
+		want string
+	}{
+		{Config{}, "unix()/"},
+		{Config{Socket: "/tmp/sock", DB: "db"}, "unix(/tmp/sock)/db"},
+		{Config{Host: "127.0.0.1", Port: 3306, Login: "user"}, "user@tcp(127.0.0.1:3306)/"},
+		{Config{Host: "db.local", Port: 3307, Login: "user", Pass: "p@ss:w/d", DB: "db"}, "user:p@ss:w/d@tcp(db.local:3307)/db"},
+		{
+			Config{Host: "::1", Port: 1, Charset: "utf8", Timeout: time.Second, ReadTimeout: 2 * time.Second, WriteTimeout: time.Minute},
+			"tcp([::1]:1)/?charset=utf8&readTimeout=2s&timeout=1s&writeTimeout=1m0s",
+		},
+	}
+	for _, c := range cases {
+		if dsn := c.cfg.DSN(); dsn != c.want {
+			t.Errorf("DSN(%+v) = %q, want %q", c.cfg, dsn, c.want)
+		}
+	}
+}
+
+type fakeDriver struct{ dsn chan string }
+
+var fake = fakeDriver{dsn: make(chan string, 1)}
+
+func init() { sql.Register("narada-mysql-fake", fake) }
+
+func (d fakeDriver) Open(dsn string) (driver.Conn, error) {
+	d.dsn <- dsn
+	return nil, errors.New("fake")
+}
+
+func TestOpen(t *testing.T) {
+	origDriverName := DriverName
+	DriverName = "narada-mysql-fake"
+	defer func() { DriverName = origDriverName }()
+
+	p := staging.New(t, staging.Txtar(`
+-- config/mysql/max_open_conns --
+5
+`))
+	db, err := OpenProject(context.Background(), p)
+	if err != nil {
+		t.Fatalf("OpenProject(), err = %v", err)
+	}
+	defer db.Close()
+	if max := db.Stats().MaxOpenConnections; max != 5 {
+		t.Errorf("MaxOpenConnections = %d, want 5", max)
+	}
+	if err = db.Ping(); err == nil {
+		t.Errorf("Ping(), err = nil")
+	}
+	want := "unix(/var/run/mysqld/mysqld.sock)/?charset=utf8mb4"
+	if dsn := <-fake.dsn; dsn != want {
+		t.Errorf("driver.Open(%q), want %q", dsn, want)
+	}
+}
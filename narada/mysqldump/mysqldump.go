@@ -0,0 +1,384 @@
+// Package mysqldump dumps MySQL database into var/mysql/ in same way as
+// narada-mysqldump tool does, to include it into project backup.
+//
+// Dump is configured using files config/mysql/dump/* with one table
+// name (or shell pattern, see path.Match) per line:
+//
+//	empty       - tables dumped without data (schema only)
+//	ignore      - tables not dumped at all
+//	incremental - tables dumped incrementally (they must have
+//	              single-column integer primary key and rows in them
+//	              must be only added, never changed)
+//
+// Dump creates these files:
+//
+//	var/mysql/db.scheme.sql              - schema of all dumped tables
+//	var/mysql/db.data.sql                - data of not empty and not incremental tables
+//	var/mysql/db.incremental.TABLE.sql   - rows of incremental table, new rows
+//	                                       are appended on each dump
+//	var/mysql/db.incremental.TABLE.last  - primary key of last dumped row
+package mysqldump
+
+import (
+	"bufio"
+	"context"
+	"database/sql"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/powerman/narada-go/narada"
+	"github.com/powerman/narada-go/narada/internal/atomicfile"
+)
+
+const dumpDir = "var/mysql"
+
+// Config contains dump settings.
+type Config struct {
+	Empty       []string `narada:"empty"`
+	Ignore      []string `narada:"ignore"`
+	Incremental []string `narada:"incremental"`
+}
+
+// LoadConfig returns Config read from config/mysql/dump/*.
+func LoadConfig() (*Config, error) { return LoadProjectConfig(context.Background(), nil) }
+
+// LoadProjectConfig works like LoadConfig but reads config of project p
+// (nil means default project) using ctx, so it may be called while ctx
+// carries narada lock (see narada.ReadConfigSnapshotContext).
+func LoadProjectConfig(ctx context.Context, p *narada.Project) (*Config, error) {
+	snap, err := project(p).ReadConfigSnapshotContext(ctx, "mysql/dump")
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err = snap.LoadConfig(&cfg); err != nil {
+		return nil, err
+	}
+	for _, patterns := range [][]string{cfg.Empty, cfg.Ignore, cfg.Incremental} {
+		for i := range patterns {
+			patterns[i] = strings.TrimSpace(patterns[i])
+			if _, err := path.Match(patterns[i], ""); err != nil {
+				return nil, fmt.Errorf("bad table pattern %q: %w", patterns[i], err)
+			}
+		}
+	}
+	return &cfg, nil
+}
+
+func project(p *narada.Project) *narada.Project {
+	if p == nil {
+		return narada.DefaultProject()
+	}
+	return p
+}
+
+// Dump dumps db into var/mysql/ using config/mysql/dump/* (see
+// LoadConfig).
+func Dump(ctx context.Context, db *sql.DB) error { return DumpProject(ctx, nil, db) }
+
+// DumpProject works like Dump but uses config and var/mysql/ of project p
+// (nil means default project).
+func DumpProject(ctx context.Context, p *narada.Project, db *sql.DB) error {
+	cfg, err := LoadProjectConfig(ctx, p)
+	if err != nil {
+		return err
+	}
+	return cfg.DumpProject(ctx, p, db)
+}
+
+// Dump dumps db into var/mysql/ under narada.SharedLock (or lock carried
+// by ctx, see narada.WithSharedLock).
+//
+// All data is dumped in a single read-only transaction to get
+// consistent dump.
+func (c *Config) Dump(ctx context.Context, db *sql.DB) error { return c.DumpProject(ctx, nil, db) }
+
+// DumpProject works like Dump but dumps into var/mysql/ of project p
+// (nil means default project).
+func (c *Config) DumpProject(ctx context.Context, p *narada.Project, db *sql.DB) error {
+	p = project(p)
+	lock, err := p.SharedLockContext(ctx)
+	if err != nil {
+		return err
+	}
+	defer lock.UnLock()
+
+	dir := p.Path(dumpDir)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tx, err := db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback() // nolint:errcheck
+
+	tables, err := listTables(ctx, tx)
+	if err != nil {
+		return err
+	}
+	var dumped []string
+	for _, table := range tables {
+		if !match(c.Ignore, table) {
+			dumped = append(dumped, table)
+		}
+	}
+
+	err = atomicfile.Write(filepath.Join(dir, "db.scheme.sql"), 0644, func(w io.Writer) error {
+		for _, table := range dumped {
+			if err := dumpSchema(ctx, tx, w, table); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	err = atomicfile.Write(filepath.Join(dir, "db.data.sql"), 0644, func(w io.Writer) error {
+		if _, err := io.WriteString(w, "SET FOREIGN_KEY_CHECKS=0;\n"); err != nil {
+			return err
+		}
+		for _, table := range dumped {
+			if match(c.Empty, table) || match(c.Incremental, table) {
+				continue
+			}
+			if _, err := dumpRows(ctx, tx, w, "INSERT", table, "", ""); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, table := range dumped {
+		if !match(c.Empty, table) && match(c.Incremental, table) {
+			if err = dumpIncremental(ctx, tx, dir, table); err != nil {
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+func match(patterns []string, table string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, table); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// listTables returns base tables (without views).
+func listTables(ctx context.Context, tx *sql.Tx) ([]string, error) {
+	rows, err := tx.QueryContext(ctx, "SHOW FULL TABLES")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var tables []string
+	for rows.Next() {
+		var name, typ string
+		if err = rows.Scan(&name, &typ); err != nil {
+			return nil, err
+		}
+		if typ == "BASE TABLE" {
+			tables = append(tables, name)
+		}
+	}
+	return tables, rows.Err()
+}
+
+func dumpSchema(ctx context.Context, tx *sql.Tx, w io.Writer, table string) error {
+	var name, create string
+	err := tx.QueryRowContext(ctx, "SHOW CREATE TABLE "+quoteName(table)).Scan(&name, &create)
+	if err != nil {
+		return fmt.Errorf("table %s: %w", table, err)
+	}
+	_, err = fmt.Fprintf(w, "DROP TABLE IF EXISTS %s;\n%s;\n\n", quoteName(table), create)
+	return err
+}
+
+// dumpRows writes insert statement for each row of table (with primary
+// key pk greater than after if pk isn't empty) and returns primary key
+// of last row.
+func dumpRows(ctx context.Context, tx *sql.Tx, w io.Writer, insert, table, pk, after string) (last string, err error) {
+	query := "SELECT * FROM " + quoteName(table)
+	var args []interface{}
+	if pk != "" {
+		query += " WHERE " + quoteName(pk) + " > ? ORDER BY " + quoteName(pk)
+		args = append(args, after)
+	}
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return "", fmt.Errorf("table %s: %w", table, err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	pkIdx := -1
+	for i, col := range cols {
+		if col == pk {
+			pkIdx = i
+		}
+	}
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	prefix := insert + " INTO " + quoteName(table) + " VALUES ("
+	var b strings.Builder
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return "", fmt.Errorf("table %s: %w", table, err)
+		}
+		b.Reset()
+		b.WriteString(prefix)
+		for i, v := range values {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(quoteValue(v))
+		}
+		b.WriteString(");\n")
+		if _, err = io.WriteString(w, b.String()); err != nil {
+			return "", err
+		}
+		if pkIdx >= 0 {
+			last = string(values[pkIdx])
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return "", fmt.Errorf("table %s: %w", table, err)
+	}
+	return last, nil
+}
+
+// dumpIncremental appends new rows of table to db.incremental.TABLE.sql.
+// All rows are dumped again if either db.incremental.TABLE.last or
+// db.incremental.TABLE.sql doesn't exist.
+//
+// Rows are appended before updating db.incremental.TABLE.last, so in
+// case of failure some rows may be dumped twice, that's why they are
+// dumped using INSERT IGNORE.
+func dumpIncremental(ctx context.Context, tx *sql.Tx, dir, table string) error {
+	pk, err := primaryKey(ctx, tx, table)
+	if err != nil {
+		return err
+	}
+	sqlName := filepath.Join(dir, "db.incremental."+table+".sql")
+	lastName := filepath.Join(dir, "db.incremental."+table+".last")
+	buf, err := ioutil.ReadFile(lastName)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	after := strings.TrimSpace(string(buf))
+	if _, err = os.Stat(sqlName); os.IsNotExist(err) {
+		after = "" // Dumped rows were lost, so dump all rows again.
+	} else if err != nil {
+		return err
+	}
+	if after == "" {
+		after = "-9223372036854775808"
+		if err = os.Remove(sqlName); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	} else if _, err = strconv.ParseInt(after, 10, 64); err != nil {
+		return fmt.Errorf("%s: %w", lastName, err)
+	}
+
+	f, err := os.OpenFile(sqlName, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	last, err := dumpRows(ctx, tx, w, "INSERT IGNORE", table, pk, after)
+	if err == nil {
+		err = w.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil || last == "" {
+		return err
+	}
+	return atomicfile.Write(lastName, 0644, func(w io.Writer) error {
+		_, err := io.WriteString(w, last+"\n")
+		return err
+	})
+}
+
+func primaryKey(ctx context.Context, tx *sql.Tx, table string) (string, error) {
+	rows, err := tx.QueryContext(ctx, "SHOW KEYS FROM "+quoteName(table)+" WHERE Key_name = 'PRIMARY'")
+	if err != nil {
+		return "", fmt.Errorf("table %s: %w", table, err)
+	}
+	defer rows.Close()
+	cols, err := rows.Columns()
+	if err != nil {
+		return "", err
+	}
+	colIdx := -1
+	for i, col := range cols {
+		if col == "Column_name" {
+			colIdx = i
+		}
+	}
+	if colIdx < 0 {
+		return "", fmt.Errorf("table %s: no Column_name in SHOW KEYS", table)
+	}
+	var pk []string
+	values := make([]sql.RawBytes, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return "", err
+		}
+		pk = append(pk, string(values[colIdx]))
+	}
+	if err = rows.Err(); err != nil {
+		return "", err
+	}
+	if len(pk) != 1 {
+		return "", fmt.Errorf("table %s: incremental table require single-column primary key", table)
+	}
+	return pk[0], nil
+}
+
+func quoteName(name string) string {
+	return "`" + strings.Replace(name, "`", "``", -1) + "`"
+}
+
+var valueEscaper = strings.NewReplacer(
+	`\`, `\\`,
+	`'`, `\'`,
+	"\x00", `\0`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\x1a", `\Z`,
+)
+
+func quoteValue(v sql.RawBytes) string {
+	if v == nil {
+		return "NULL"
+	}
+	return "'" + valueEscaper.Replace(string(v)) + "'"
+}
@@ -0,0 +1,294 @@
+package mysqldump
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/powerman/narada-go/narada"
+	"github.com/powerman/narada-go/narada/internal/testutil"
+	"github.com/powerman/narada-go/narada/staging"
+)
+
+// fakeTable is a table (or a view) in fakeDB. First column must be an
+// int64 primary key.
+type fakeTable struct {
+	name string
+	view bool
+	pk   []string
+	cols []string
+	rows [][]driver.Value
+}
+
+// fakeDB is a database/sql driver which supports only queries used by
+// Dump.
+type fakeDB struct {
+	mu     sync.Mutex
+	tables []*fakeTable
+	readTx bool // Was transaction read-only.
+}
+
+func (db *fakeDB) Open(string) (driver.Conn, error) { return fakeConn{db}, nil }
+
+func (db *fakeDB) table(name string) *fakeTable {
+	for _, t := range db.tables {
+		if t.name == name {
+			return t
+		}
+	}
+	return nil
+}
+
+type fakeConn struct{ db *fakeDB }
+
+func (fakeConn) Prepare(string) (driver.Stmt, error) { return nil, errors.New("not supported") }
+func (fakeConn) Close() error                        { return nil }
+func (fakeConn) Begin() (driver.Tx, error)           { return fakeTx{}, nil }
+
+func (c fakeConn) BeginTx(_ context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	c.db.mu.Lock()
+	defer c.db.mu.Unlock()
+	c.db.readTx = opts.ReadOnly && sql.IsolationLevel(opts.Isolation) == sql.LevelRepeatableRead
+	return fakeTx{}, nil
+}
+
+type fakeTx struct{}
+
+func (fakeTx) Commit() error   { return nil }
+func (fakeTx) Rollback() error { return nil }
+
+var (
+	reShowCreate = regexp.MustCompile("^SHOW CREATE TABLE `(.*)`$")
+	reShowKeys   = regexp.MustCompile("^SHOW KEYS FROM `(.*)` WHERE Key_name = 'PRIMARY'$")
+	reSelect     = regexp.MustCompile("^SELECT \\* FROM `(.*?)`(?: WHERE `(.*)` > \\? ORDER BY `.*`)?$")
+)
+
+func (c fakeConn) QueryContext(_ context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	db := c.db
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	switch m := reShowCreate.FindStringSubmatch(query); {
+	case query == "SHOW FULL TABLES":
+		r := &fakeRows{cols: []string{"Tables_in_db", "Table_type"}}
+		for _, t := range db.tables {
+			typ := "BASE TABLE"
+			if t.view {
+				typ = "VIEW"
+			}
+			r.rows = append(r.rows, []driver.Value{t.name, typ})
+		}
+		return r, nil
+	case m != nil:
+		t := db.table(m[1])
+		if t == nil {
+			return nil, fmt.Errorf("no table %s", m[1])
+		}
+		create := "CREATE TABLE `" + t.name + "` (" + strings.Join(t.cols, ",") + ")"
+		return &fakeRows{cols: []string{"Table", "Create Table"}, rows: [][]driver.Value{{t.name, create}}}, nil
+	}
+	if m := reShowKeys.FindStringSubmatch(query); m != nil {
+		r := &fakeRows{cols: []string{"Table", "Key_name", "Column_name"}}
+		for _, col := range db.table(m[1]).pk {
+			r.rows = append(r.rows, []driver.Value{m[1], "PRIMARY", col})
+		}
+		return r, nil
+	}
+	if m := reSelect.FindStringSubmatch(query); m != nil {
+		t := db.table(m[1])
+		r := &fakeRows{cols: t.cols}
+		for _, row := range t.rows {
+			if m[2] != "" {
+				after, err := strconv.ParseInt(args[0].Value.(string), 10, 64)
+				if err != nil {
+					return nil, err
+				}
+				if row[0].(int64) <= after {
+					continue
+				}
+			}
+			r.rows = append(r.rows, row)
+		}
+		return r, nil
+	}
+	return nil, fmt.Errorf("unsupported query: %s", query)
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+var fake = &fakeDB{}
+
+func init() { sql.Register("narada-mysqldump-fake", fake) }
+
+func TestLoadConfig(t *testing.T) {
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatalf("LoadConfig(), err = %v", err)
+	}
+	if want := (&Config{}); !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadConfig() = %#v, want %#v", cfg, want)
+	}
+
+	defer narada.SetConfig("mysql/dump/ignore", nil)
+	if err = narada.SetConfig("mysql/dump/ignore", []byte("log_*\n\n tmp \n")); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err = LoadConfig()
+	if want := (&Config{Ignore: []string{"log_*", "tmp"}}); err != nil || !reflect.DeepEqual(cfg, want) {
+		t.Errorf("LoadConfig() = %#v, %v, want %#v", cfg, err, want)
+	}
+
+	if err = narada.SetConfig("mysql/dump/ignore", []byte("[\n")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = LoadConfig(); err == nil {
+		t.Errorf("LoadConfig(), err = nil")
+	}
+}
+
+func TestQuoteValue(t *testing.T) {
+	cases := []struct {
+		v    sql.RawBytes
+		want string
+	}{
+		{nil, "NULL"},
+		{sql.RawBytes{}, "''"},
+		{sql.RawBytes("42"), "'42'"},
+		{sql.RawBytes("it's\n\\\x00\r\x1a"), `'it\'s\n\\\0\r\Z'`},
+	}
+	for _, c := range cases {
+		if got := quoteValue(c.v); got != c.want {
+			t.Errorf("quoteValue(%q) = %s, want %s", c.v, got, c.want)
+		}
+	}
+	if got, want := quoteName("a`b"), "`a``b`"; got != want {
+		t.Errorf("quoteName() = %s, want %s", got, want)
+	}
+}
+
+func TestDump(t *testing.T) {
+	ctx := context.Background()
+	fake.tables = []*fakeTable{
+		{name: "user", pk: []string{"id"}, cols: []string{"id", "name"}, rows: [][]driver.Value{
+			{int64(1), "Alex"},
+			{int64(2), nil},
+		}},
+		{name: "user_view", view: true, cols: []string{"id"}},
+		{name: "session", pk: []string{"id"}, cols: []string{"id"}, rows: [][]driver.Value{{int64(1)}}},
+		{name: "log_2020", pk: []string{"id"}, cols: []string{"id"}, rows: [][]driver.Value{{int64(1)}}},
+		{name: "event", pk: []string{"id"}, cols: []string{"id", "msg"}, rows: [][]driver.Value{
+			{int64(1), "a"},
+			{int64(2), "b"},
+		}},
+	}
+	p := staging.New(t, staging.Txtar(`
+-- config/mysql/dump/empty --
+session
+-- config/mysql/dump/ignore --
+log_*
+-- config/mysql/dump/incremental --
+event
+`))
+	db, err := sql.Open("narada-mysqldump-fake", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	if err = DumpProject(ctx, p, db); err != nil {
+		t.Fatalf("DumpProject(), err = %v", err)
+	}
+	if !fake.readTx {
+		t.Errorf("Dump() must use read-only repeatable read transaction")
+	}
+	wantScheme := "" +
+		"DROP TABLE IF EXISTS `user`;\nCREATE TABLE `user` (id,name);\n\n" +
+		"DROP TABLE IF EXISTS `session`;\nCREATE TABLE `session` (id);\n\n" +
+		"DROP TABLE IF EXISTS `event`;\nCREATE TABLE `event` (id,msg);\n\n"
+	if got := testutil.ReadFile(t, p.Path("var/mysql/db.scheme.sql")); got != wantScheme {
+		t.Errorf("db.scheme.sql = %q, want %q", got, wantScheme)
+	}
+	wantData := "SET FOREIGN_KEY_CHECKS=0;\n" +
+		"INSERT INTO `user` VALUES ('1','Alex');\n" +
+		"INSERT INTO `user` VALUES ('2',NULL);\n"
+	if got := testutil.ReadFile(t, p.Path("var/mysql/db.data.sql")); got != wantData {
+		t.Errorf("db.data.sql = %q, want %q", got, wantData)
+	}
+	wantIncr := "INSERT IGNORE INTO `event` VALUES ('1','a');\n" +
+		"INSERT IGNORE INTO `event` VALUES ('2','b');\n"
+	if got := testutil.ReadFile(t, p.Path("var/mysql/db.incremental.event.sql")); got != wantIncr {
+		t.Errorf("db.incremental.event.sql = %q, want %q", got, wantIncr)
+	}
+	if got := testutil.ReadFile(t, p.Path("var/mysql/db.incremental.event.last")); got != "2\n" {
+		t.Errorf("db.incremental.event.last = %q, want %q", got, "2\n")
+	}
+
+	fake.tables[4].rows = append(fake.tables[4].rows, []driver.Value{int64(3), "c"})
+	cfg, err := LoadProjectConfig(ctx, p)
+	if err != nil {
+		t.Fatalf("LoadProjectConfig(), err = %v", err)
+	}
+	if err = cfg.DumpProject(ctx, p, db); err != nil {
+		t.Fatalf("DumpProject(), err = %v", err)
+	}
+	wantIncr += "INSERT IGNORE INTO `event` VALUES ('3','c');\n"
+	if got := testutil.ReadFile(t, p.Path("var/mysql/db.incremental.event.sql")); got != wantIncr {
+		t.Errorf("db.incremental.event.sql = %q, want %q", got, wantIncr)
+	}
+	if got := testutil.ReadFile(t, p.Path("var/mysql/db.incremental.event.last")); got != "3\n" {
+		t.Errorf("db.incremental.event.last = %q, want %q", got, "3\n")
+	}
+
+	if err = os.Remove(p.Path("var/mysql/db.incremental.event.last")); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.DumpProject(ctx, p, db); err != nil {
+		t.Fatalf("DumpProject(), err = %v", err)
+	}
+	if got := strings.Count(testutil.ReadFile(t, p.Path("var/mysql/db.incremental.event.sql")), "\n"); got != 3 {
+		t.Errorf("db.incremental.event.sql has %d rows after reset, want 3", got)
+	}
+
+	if err = os.Remove(p.Path("var/mysql/db.incremental.event.sql")); err != nil {
+		t.Fatal(err)
+	}
+	if err = cfg.DumpProject(ctx, p, db); err != nil {
+		t.Fatalf("DumpProject(), err = %v", err)
+	}
+	if got := testutil.ReadFile(t, p.Path("var/mysql/db.incremental.event.sql")); got != wantIncr {
+		t.Errorf("db.incremental.event.sql after .sql removed = %q, want %q", got, wantIncr)
+	}
+	if got := testutil.ReadFile(t, p.Path("var/mysql/db.incremental.event.last")); got != "3\n" {
+		t.Errorf("db.incremental.event.last = %q, want %q", got, "3\n")
+	}
+
+	fake.tables[0].pk = []string{"id", "name"}
+	cfg.Incremental = []string{"user"}
+	if err = cfg.DumpProject(ctx, p, db); err == nil || !strings.Contains(err.Error(), "single-column primary key") {
+		t.Errorf("DumpProject(), err = %v, want single-column primary key error", err)
+	}
+}
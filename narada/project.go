@@ -0,0 +1,53 @@
+package narada
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// Project is a Narada project. It makes it possible to work with several
+// projects in one process. Package-level functions work with default
+// project (see DefaultProject) and each of them has corresponding
+// Project method.
+//
+// Zero value is not usable, use NewProject.
+type Project struct {
+	configReader
+	rootMu      sync.Mutex
+	root        string // Empty until detected or set.
+	open        func(name string) (io.ReadCloser, error)
+	log         atomic.Pointer[logState]
+	reloadLogMu sync.Mutex
+}
+
+var defaultProject = newProject()
+
+// DefaultProject returns project used by package-level functions.
+func DefaultProject() *Project {
+	return defaultProject
+}
+
+// NewProject returns project with given root directory. Empty root
+// means it will be detected in same way as for default project (see
+// Root).
+//
+// Log of returned project is not configured (it outputs all messages
+// using log package) until ReloadLog will be called.
+func NewProject(root string) (*Project, error) {
+	p := newProject()
+	if err := p.SetRoot(root); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func newProject() *Project {
+	p := &Project{}
+	p.configReader = configReader{lookup: p.lookupConfigFile}
+	p.open = func(name string) (io.ReadCloser, error) {
+		return os.Open(p.Path(name))
+	}
+	return p
+}
@@ -0,0 +1,143 @@
+package narada
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func newTestProject(t *testing.T, version string) *Project {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "test-narada-project.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	files := map[string]string{
+		"VERSION":          version + "\n",
+		"config/name":      version + "\n",
+		"config/log/level": "INFO\n",
+		"config/log/type":  "file\n",
+		"config/log/file":  "var/log\n",
+	}
+	for name, data := range files {
+		name = filepath.Join(dir, name)
+		if err = os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.Mkdir(filepath.Join(dir, "var"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	p, err := NewProject(dir)
+	if err != nil {
+		t.Fatalf("NewProject(%q), err = %v", dir, err)
+	}
+	if p.Root() != dir {
+		t.Errorf("Root() = %q, want %q", p.Root(), dir)
+	}
+	return p
+}
+
+func TestNewProject(t *testing.T) {
+	if _, err := NewProject("nosuch"); !os.IsNotExist(err) {
+		t.Errorf("NewProject(nosuch), err = %v", err)
+	}
+	if DefaultProject() != defaultProject {
+		t.Errorf("DefaultProject() = %p, want %p", DefaultProject(), defaultProject)
+	}
+}
+
+func TestProject(t *testing.T) {
+	p1 := newTestProject(t, "1.0.0")
+	p2 := newTestProject(t, "2.0.0")
+
+	for _, c := range []struct {
+		p    *Project
+		want string
+	}{
+		{p1, "1.0.0"},
+		{p2, "2.0.0"},
+		{defaultProject, "1.2.3+example-1234567890"},
+	} {
+		if v, err := c.p.Version(); err != nil || v != c.want {
+			t.Errorf("Version() = %q, %v, want %q", v, err, c.want)
+		}
+		if c.p == defaultProject {
+			continue
+		}
+		if line := c.p.GetConfigLine("name"); line != c.want {
+			t.Errorf("GetConfigLine(name) = %q, want %q", line, c.want)
+		}
+		if ok, err := c.p.VersionAtLeast("2"); err != nil || ok != (c.want == "2.0.0") {
+			t.Errorf("VersionAtLeast(2) = %v, %v", ok, err)
+		}
+	}
+
+	if err := p1.SetConfigLine("name", "changed"); err != nil {
+		t.Fatal(err)
+	}
+	if line := p1.GetConfigLine("name"); line != "changed" {
+		t.Errorf("GetConfigLine(name) = %q, want %q", line, "changed")
+	}
+	if line := p2.GetConfigLine("name"); line != "2.0.0" {
+		t.Errorf("GetConfigLine(name) = %q, want %q", line, "2.0.0")
+	}
+	var cfg struct {
+		Name string `narada:"name"`
+	}
+	if err := p2.LoadConfig(&cfg); err != nil || cfg.Name != "2.0.0" {
+		t.Errorf("LoadConfig() = %q, %v", cfg.Name, err)
+	}
+	p2.FakeConfig(map[string]string{"name": "fake"})
+	if line := p2.GetConfigLine("name"); line != "fake" {
+		t.Errorf("GetConfigLine(name) = %q, want %q", line, "fake")
+	}
+	if line := GetConfigLine("name"); line != "" {
+		t.Errorf("GetConfigLine(name) = %q, want empty", line)
+	}
+
+	lock, err := p1.ExclusiveLock(tick)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(p1.Path(".lock.new")); err != nil {
+		t.Errorf(".lock.new: %v", err)
+	}
+	lock2, err := p2.SharedLock(tick)
+	if err != nil {
+		t.Errorf("SharedLock() in other project, err = %v", err)
+	}
+	lock2.UnLock()
+	if _, err = p1.SharedLock(tick); !errors.Is(err, ErrLockTimeout) {
+		t.Errorf("SharedLock(), err = %v, want %v", err, ErrLockTimeout)
+	}
+	lock.UnLock()
+
+	for _, p := range []*Project{p1, p2} {
+		if err = p.ReloadLog(); err != nil {
+			t.Fatalf("ReloadLog(), err = %v", err)
+		}
+		defer p.setLog(&logState{level: LogDEBUG})
+	}
+	p1.NewLog("").NOTICE("one")
+	p2.NewLog("").NOTICE("two")
+	for _, c := range []struct {
+		p    *Project
+		want string
+	}{
+		{p1, ": NOTICE: one\n"},
+		{p2, ": NOTICE: two\n"},
+	} {
+		buf, err := ioutil.ReadFile(c.p.Path("var/log"))
+		if err != nil || !strings.HasSuffix(string(buf), c.want) || strings.Count(string(buf), "\n") != 1 {
+			t.Errorf("log = %q, %v, want suffix %q", buf, err, c.want)
+		}
+	}
+}
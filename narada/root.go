@@ -0,0 +1,104 @@
+package narada
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+)
+
+// Root returns absolute path to project root directory of default
+// project.
+//
+// Unless it was set using SetRoot it's detected on first call:
+//   - $NARADA_DIR, if not empty;
+//   - first of current directory and its parents which contains both
+//     VERSION file and config/ directory;
+//   - current directory.
+//
+// Result is cached, so changing current directory after detection won't
+// change project root.
+//
+// It returns empty string (i.e. paths will be relative to current
+// directory) only if current directory can't be detected.
+func Root() string { return defaultProject.Root() }
+
+// SetRoot sets project root directory of default project. Empty dir will
+// make next Root call detect it again.
+//
+// Log configuration is not affected until ReloadLog will be called.
+func SetRoot(dir string) error { return defaultProject.SetRoot(dir) }
+
+// Path returns name (relative to project root) resolved against Root.
+// Absolute names are returned as is.
+func Path(name string) string { return defaultProject.Path(name) }
+
+// Root works like package-level Root.
+func (p *Project) Root() string {
+	p.rootMu.Lock()
+	defer p.rootMu.Unlock()
+	if p.root == "" {
+		p.root = detectRoot()
+	}
+	return p.root
+}
+
+// SetRoot works like package-level SetRoot.
+func (p *Project) SetRoot(dir string) error {
+	if dir != "" {
+		var err error
+		if dir, err = filepath.Abs(dir); err != nil {
+			return err
+		}
+		fi, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			return &os.PathError{Op: "SetRoot", Path: dir, Err: errors.New("not a directory")}
+		}
+	}
+	p.rootMu.Lock()
+	defer p.rootMu.Unlock()
+	p.root = dir
+	return nil
+}
+
+// Path works like package-level Path.
+func (p *Project) Path(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(p.Root(), name)
+}
+
+func detectRoot() string {
+	if dir := os.Getenv("NARADA_DIR"); dir != "" {
+		if abs, err := filepath.Abs(dir); err == nil {
+			return abs
+		}
+		return dir
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	for dir := cwd; ; {
+		if isRoot(dir) {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return cwd
+		}
+		dir = parent
+	}
+}
+
+func isRoot(dir string) bool {
+	fi, err := os.Stat(filepath.Join(dir, "VERSION"))
+	if err != nil || !fi.Mode().IsRegular() {
+		return false
+	}
+	fi, err = os.Stat(filepath.Join(dir, "config"))
+	return err == nil && fi.IsDir()
+}
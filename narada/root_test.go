@@ -0,0 +1,79 @@
+package narada
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRoot(t *testing.T) {
+	root := Root()
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(cwd)
+	defer SetRoot(root)
+	if root != cwd {
+		t.Errorf("Root() = %q, want %q", root, cwd)
+	}
+
+	subdir := filepath.Join(root, "var", "a", "b")
+	if err = os.MkdirAll(subdir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(filepath.Join(root, "var", "a"))
+	other, err := ioutil.TempDir("", "test-narada-other.")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(other)
+	file := filepath.Join(other, "file")
+	if err = ioutil.WriteFile(file, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		dir, env, want string
+	}{
+		{subdir, "", root},
+		{other, "", other},
+		{other, root, root},
+		{subdir, "var", filepath.Join(subdir, "var")},
+	}
+	for _, c := range cases {
+		t.Setenv("NARADA_DIR", c.env)
+		if err = os.Chdir(c.dir); err != nil {
+			t.Fatal(err)
+		}
+		if err = SetRoot(""); err != nil {
+			t.Fatal(err)
+		}
+		if got := Root(); got != c.want {
+			t.Errorf("Root() in %q with NARADA_DIR=%q = %q, want %q", c.dir, c.env, got, c.want)
+		}
+	}
+
+	if err = os.Chdir(other); err != nil {
+		t.Fatal(err)
+	}
+	if err = SetRoot("nosuch"); !os.IsNotExist(err) {
+		t.Errorf("SetRoot(nosuch), err = %v", err)
+	}
+	if err = SetRoot("file"); err == nil {
+		t.Errorf("SetRoot(file), err = nil")
+	}
+	if err = SetRoot(subdir); err != nil {
+		t.Errorf("SetRoot(%q), err = %v", subdir, err)
+	}
+	if got := Root(); got != subdir {
+		t.Errorf("Root() = %q, want %q", got, subdir)
+	}
+	if got, want := Path("config/file"), filepath.Join(subdir, "config", "file"); got != want {
+		t.Errorf("Path() = %q, want %q", got, want)
+	}
+	if got, want := Path("/dev/null"), "/dev/null"; got != want {
+		t.Errorf("Path() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,280 @@
+// Package services controls project services supervised by runit.
+//
+// Each service is a directory service/NAME/ (relative to project root)
+// with executable ./run file, supervised by runsv(8). This package talks
+// to runsv directly using files in service/NAME/supervise/, so it
+// doesn't need sv(8).
+//
+// Service may report it's ready to serve using Ready. For this to work
+// ./run must exec service binary (to keep pid reported by runsv).
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/powerman/narada-go/narada"
+)
+
+const (
+	serviceDir = "service"
+	statusSize = 20
+	// taiOffset is TAI64 label of 1970-01-01 00:00:00 TAI.
+	taiOffset = 4611686018427387914
+)
+
+// PollInterval is a delay between checks of service status while
+// waiting for it to change.
+var PollInterval = 100 * time.Millisecond
+
+// ErrNotSupervised means runsv isn't running for the service.
+var ErrNotSupervised = errors.New("service is not supervised")
+
+// State is a state of service process.
+type State byte
+
+// Service states.
+const (
+	Down   State = iota // Not running.
+	Run                 // Running.
+	Finish              // Running ./finish.
+)
+
+func (s State) String() string {
+	switch s {
+	case Down:
+		return "down"
+	case Run:
+		return "run"
+	case Finish:
+		return "finish"
+	}
+	return "unknown(" + strconv.Itoa(int(s)) + ")"
+}
+
+// Status describes service status as reported by runsv.
+type Status struct {
+	Name  string
+	State State
+	PID   int       // Zero if service isn't running.
+	Since time.Time // When service changed State.
+	// Want is a State runsv will try to bring service to.
+	Want   State
+	Paused bool
+	// NormallyUp is false if service/NAME/down file exists.
+	NormallyUp bool
+	// Ready is true if service is running and it's current process
+	// reported readiness using Ready.
+	Ready bool
+}
+
+// Services controls services of a project. Package-level functions
+// control services of default project and each of them has
+// corresponding Services method.
+type Services struct {
+	p *narada.Project
+}
+
+var defaultServices = New(nil)
+
+// New returns Services of project p (nil means default project).
+func New(p *narada.Project) *Services {
+	if p == nil {
+		p = narada.DefaultProject()
+	}
+	return &Services{p: p}
+}
+
+func (sv *Services) path(name string, elem ...string) string {
+	return sv.p.Path(filepath.Join(append([]string{serviceDir, name}, elem...)...))
+}
+
+// List returns names of all project services.
+func List() ([]string, error) { return defaultServices.List() }
+
+// List works like package-level List.
+func (sv *Services) List() ([]string, error) {
+	fis, err := ioutil.ReadDir(sv.p.Path(serviceDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, fi := range fis {
+		if fi.IsDir() || fi.Mode()&os.ModeSymlink != 0 {
+			if _, err := os.Stat(sv.path(fi.Name(), "run")); err == nil {
+				names = append(names, fi.Name())
+			}
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// GetStatus returns current status of service.
+func GetStatus(name string) (*Status, error) { return defaultServices.GetStatus(name) }
+
+// GetStatus works like package-level GetStatus.
+func (sv *Services) GetStatus(name string) (*Status, error) {
+	// runsv keeps supervise/ok open for reading while it's running.
+	f, err := os.OpenFile(sv.path(name, "supervise", "ok"), os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return nil, supervisedErr(name, err)
+	}
+	f.Close()
+
+	buf, err := ioutil.ReadFile(sv.path(name, "supervise", "status"))
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) != statusSize {
+		return nil, fmt.Errorf("%s: bad supervise/status size %d", name, len(buf))
+	}
+	sec := binary.BigEndian.Uint64(buf[0:8])
+	nsec := binary.BigEndian.Uint32(buf[8:12])
+	s := &Status{
+		Name:   name,
+		Since:  time.Unix(int64(sec-taiOffset), int64(nsec)),
+		PID:    int(binary.LittleEndian.Uint32(buf[12:16])),
+		Paused: buf[16] != 0,
+		State:  State(buf[19]),
+	}
+	switch buf[17] {
+	case 'u':
+		s.Want = Run
+	case 'd':
+		s.Want = Down
+	default:
+		s.Want = s.State
+	}
+	_, err = os.Stat(sv.path(name, "down"))
+	s.NormallyUp = os.IsNotExist(err)
+	if s.State == Run && s.PID != 0 {
+		ready, err := ioutil.ReadFile(sv.path(name, "supervise", "ready"))
+		s.Ready = err == nil && strings.TrimSpace(string(ready)) == strconv.Itoa(s.PID)
+	}
+	return s, nil
+}
+
+func supervisedErr(name string, err error) error {
+	if errors.Is(err, syscall.ENXIO) || os.IsNotExist(err) {
+		return fmt.Errorf("%s: %w", name, ErrNotSupervised)
+	}
+	return err
+}
+
+// control sends commands (see runsv(8)) to runsv of service.
+func (sv *Services) control(name, cmds string) error {
+	f, err := os.OpenFile(sv.path(name, "supervise", "control"), os.O_WRONLY|syscall.O_NONBLOCK, 0)
+	if err != nil {
+		return supervisedErr(name, err)
+	}
+	defer f.Close()
+	_, err = f.WriteString(cmds)
+	return err
+}
+
+// Start starts service and waits until it's running or ctx is done.
+func Start(ctx context.Context, name string) error { return defaultServices.Start(ctx, name) }
+
+// Start works like package-level Start.
+func (sv *Services) Start(ctx context.Context, name string) error {
+	if err := sv.control(name, "u"); err != nil {
+		return err
+	}
+	_, err := sv.wait(ctx, name, func(s *Status) bool { return s.State == Run })
+	return err
+}
+
+// Stop stops service and waits until it's down or ctx is done.
+func Stop(ctx context.Context, name string) error { return defaultServices.Stop(ctx, name) }
+
+// Stop works like package-level Stop.
+func (sv *Services) Stop(ctx context.Context, name string) error {
+	if err := sv.control(name, "d"); err != nil {
+		return err
+	}
+	_, err := sv.wait(ctx, name, func(s *Status) bool { return s.State == Down })
+	return err
+}
+
+// Restart restarts service (starts it if it's down) and waits until new
+// process is running or ctx is done.
+func Restart(ctx context.Context, name string) error { return defaultServices.Restart(ctx, name) }
+
+// Restart works like package-level Restart.
+func (sv *Services) Restart(ctx context.Context, name string) error {
+	old, err := sv.GetStatus(name)
+	if err != nil {
+		return err
+	}
+	if err = sv.control(name, "tcu"); err != nil {
+		return err
+	}
+	_, err = sv.wait(ctx, name, func(s *Status) bool {
+		return s.State == Run && (s.PID != old.PID || s.Since.After(old.Since))
+	})
+	return err
+}
+
+// WaitReady waits until service is running and reported readiness
+// using Ready or ctx is done.
+func WaitReady(ctx context.Context, name string) error { return defaultServices.WaitReady(ctx, name) }
+
+// WaitReady works like package-level WaitReady.
+func (sv *Services) WaitReady(ctx context.Context, name string) error {
+	_, err := sv.wait(ctx, name, func(s *Status) bool { return s.Ready })
+	return err
+}
+
+func (sv *Services) wait(ctx context.Context, name string, done func(*Status) bool) (*Status, error) {
+	ticker := time.NewTicker(PollInterval)
+	defer ticker.Stop()
+	for {
+		s, err := sv.GetStatus(name)
+		if err != nil {
+			return nil, err
+		}
+		if done(s) {
+			return s, nil
+		}
+		select {
+		case <-ctx.Done():
+			return s, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Ready should be called by service binary when it's ready to serve.
+// Service's readiness is reset when it's restarted.
+func Ready(name string) error { return defaultServices.Ready(name) }
+
+// Ready works like package-level Ready.
+func (sv *Services) Ready(name string) error {
+	dir := sv.path(name, "supervise")
+	f, err := ioutil.TempFile(dir, ".ready.")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	_, err = f.WriteString(strconv.Itoa(os.Getpid()) + "\n")
+	if errClose := f.Close(); err == nil {
+		err = errClose
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), filepath.Join(dir, "ready"))
+}
@@ -0,0 +1,250 @@
+package services
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+
+	"github.com/powerman/narada-go/narada"
+	"github.com/powerman/narada-go/narada/staging"
+)
+
+// fakeRunsv emulates runsv(8) for service name of sv's project.
+type fakeRunsv struct {
+	t       *testing.T
+	sv      *Services
+	name    string
+	control *os.File
+	ok      *os.File
+	pid     int
+	done    chan struct{}
+}
+
+func startRunsv(t *testing.T, sv *Services, name string, up bool) *fakeRunsv {
+	t.Helper()
+	dir := sv.path(name, "supervise")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(sv.path(name, "run"), []byte("#!/bin/sh\nexec sleep 1000\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for _, fifo := range []string{"control", "ok"} {
+		if err := unix.Mkfifo(sv.path(name, "supervise", fifo), 0600); err != nil && !os.IsExist(err) {
+			t.Fatal(err)
+		}
+	}
+	r := &fakeRunsv{t: t, sv: sv, name: name, pid: 1000, done: make(chan struct{})}
+	var err error
+	if r.control, err = os.OpenFile(sv.path(name, "supervise", "control"), os.O_RDWR, 0); err != nil {
+		t.Fatal(err)
+	}
+	if r.ok, err = os.OpenFile(sv.path(name, "supervise", "ok"), os.O_RDONLY|unix.O_NONBLOCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	if up {
+		r.writeStatus(Run, 'u')
+	} else {
+		r.writeStatus(Down, 'd')
+	}
+	go r.serve()
+	t.Cleanup(r.stop)
+	return r
+}
+
+func (r *fakeRunsv) writeStatus(state State, want byte) {
+	buf := make([]byte, statusSize)
+	now := time.Now()
+	binary.BigEndian.PutUint64(buf[0:8], uint64(now.Unix())+taiOffset)
+	binary.BigEndian.PutUint32(buf[8:12], uint32(now.Nanosecond()))
+	if state == Run {
+		binary.LittleEndian.PutUint32(buf[12:16], uint32(r.pid))
+	}
+	buf[17] = want
+	buf[19] = byte(state)
+	name := r.sv.path(r.name, "supervise", "status")
+	if err := ioutil.WriteFile(name+".new", buf, 0644); err != nil {
+		r.t.Error(err)
+	}
+	if err := os.Rename(name+".new", name); err != nil {
+		r.t.Error(err)
+	}
+}
+
+func (r *fakeRunsv) serve() {
+	defer close(r.done)
+	buf := make([]byte, 16)
+	for {
+		n, err := r.control.Read(buf)
+		if err != nil {
+			return
+		}
+		for _, cmd := range buf[:n] {
+			switch cmd {
+			case 'u':
+				r.writeStatus(Run, 'u')
+			case 'd':
+				r.writeStatus(Down, 'd')
+			case 't':
+				r.pid++
+			case 'x':
+				return
+			}
+		}
+	}
+}
+
+func (r *fakeRunsv) stop() {
+	if err := r.sv.control(r.name, "x"); err == nil {
+		<-r.done
+	}
+	r.control.Close()
+	r.ok.Close()
+}
+
+func TestList(t *testing.T) {
+	defer os.RemoveAll(narada.Path(serviceDir))
+	names, err := List()
+	if err != nil || names != nil {
+		t.Errorf("List() = %q, %v, want nil", names, err)
+	}
+	for _, name := range []string{"web", "api"} {
+		if err = os.MkdirAll(defaultServices.path(name), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err = ioutil.WriteFile(defaultServices.path(name, "run"), nil, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = os.MkdirAll(defaultServices.path("norun"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	names, err = List()
+	if want := []string{"api", "web"}; err != nil || !reflect.DeepEqual(names, want) {
+		t.Errorf("List() = %q, %v, want %q", names, err, want)
+	}
+}
+
+func TestControl(t *testing.T) {
+	defer os.RemoveAll(narada.Path(serviceDir))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	PollInterval = time.Millisecond
+
+	if _, err := GetStatus("nosuch"); !errors.Is(err, ErrNotSupervised) {
+		t.Errorf("GetStatus(nosuch), err = %v, want %v", err, ErrNotSupervised)
+	}
+	if err := Start(ctx, "nosuch"); !errors.Is(err, ErrNotSupervised) {
+		t.Errorf("Start(nosuch), err = %v, want %v", err, ErrNotSupervised)
+	}
+
+	r := startRunsv(t, defaultServices, "web", false)
+	if err := ioutil.WriteFile(defaultServices.path("web", "down"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	s, err := GetStatus("web")
+	if err != nil {
+		t.Fatalf("GetStatus(), err = %v", err)
+	}
+	if s.Name != "web" || s.State != Down || s.Want != Down || s.PID != 0 || s.NormallyUp || s.Ready {
+		t.Errorf("GetStatus() = %+v", s)
+	}
+	if since := time.Since(s.Since); since < 0 || since > time.Minute {
+		t.Errorf("GetStatus().Since = %v", s.Since)
+	}
+
+	if err = Start(ctx, "web"); err != nil {
+		t.Errorf("Start(), err = %v", err)
+	}
+	if s, err = GetStatus("web"); err != nil || s.State != Run || s.PID != 1000 {
+		t.Errorf("GetStatus() = %+v, %v", s, err)
+	}
+
+	if err = Restart(ctx, "web"); err != nil {
+		t.Errorf("Restart(), err = %v", err)
+	}
+	if s, err = GetStatus("web"); err != nil || s.State != Run || s.PID != 1001 {
+		t.Errorf("GetStatus() = %+v, %v", s, err)
+	}
+
+	if err = Stop(ctx, "web"); err != nil {
+		t.Errorf("Stop(), err = %v", err)
+	}
+	if s, err = GetStatus("web"); err != nil || s.State != Down {
+		t.Errorf("GetStatus() = %+v, %v", s, err)
+	}
+
+	r.stop()
+	if _, err = GetStatus("web"); !errors.Is(err, ErrNotSupervised) {
+		t.Errorf("GetStatus(), err = %v, want %v", err, ErrNotSupervised)
+	}
+}
+
+func TestReady(t *testing.T) {
+	defer os.RemoveAll(narada.Path(serviceDir))
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	PollInterval = time.Millisecond
+
+	r := startRunsv(t, defaultServices, "api", true)
+	r.pid = os.Getpid()
+	r.writeStatus(Run, 'u')
+
+	waitCtx, waitCancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer waitCancel()
+	if err := WaitReady(waitCtx, "api"); err != context.DeadlineExceeded {
+		t.Errorf("WaitReady(), err = %v, want %v", err, context.DeadlineExceeded)
+	}
+
+	if err := Ready("api"); err != nil {
+		t.Fatalf("Ready(), err = %v", err)
+	}
+	buf, err := ioutil.ReadFile(defaultServices.path("api", "supervise", "ready"))
+	if want := strconv.Itoa(os.Getpid()) + "\n"; err != nil || string(buf) != want {
+		t.Errorf("ready = %q, %v, want %q", buf, err, want)
+	}
+	if err = WaitReady(ctx, "api"); err != nil {
+		t.Errorf("WaitReady(), err = %v", err)
+	}
+	if s, err := GetStatus("api"); err != nil || !s.Ready || !s.NormallyUp {
+		t.Errorf("GetStatus() = %+v, %v", s, err)
+	}
+
+	if err = Restart(ctx, "api"); err != nil {
+		t.Errorf("Restart(), err = %v", err)
+	}
+	if s, err := GetStatus("api"); err != nil || s.Ready {
+		t.Errorf("GetStatus() after restart = %+v, %v", s, err)
+	}
+}
+
+func TestProject(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	PollInterval = time.Millisecond
+
+	sv := New(staging.New(t))
+	startRunsv(t, sv, "web", false)
+
+	names, err := sv.List()
+	if want := []string{"web"}; err != nil || !reflect.DeepEqual(names, want) {
+		t.Errorf("List() = %q, %v, want %q", names, err, want)
+	}
+	if err = sv.Start(ctx, "web"); err != nil {
+		t.Errorf("Start(), err = %v", err)
+	}
+	if s, err := sv.GetStatus("web"); err != nil || s.State != Run {
+		t.Errorf("GetStatus() = %+v, %v", s, err)
+	}
+	if _, err = GetStatus("web"); !errors.Is(err, ErrNotSupervised) {
+		t.Errorf("GetStatus() of default project, err = %v, want %v", err, ErrNotSupervised)
+	}
+}
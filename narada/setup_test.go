@@ -16,6 +16,10 @@ func TestMain(m *testing.M) {
 	if err != nil {
 		log.Fatal(err)
 	}
+	err = SetRoot(tmpdir)
+	if err != nil {
+		log.Fatal(err)
+	}
 	setupTestDir()
 	if err != nil {
 		log.Fatal(err)
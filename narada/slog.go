@@ -0,0 +1,138 @@
+package narada
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+)
+
+// SlogLevelNotice is a slog level which corresponds to LogNOTICE.
+const SlogLevelNotice = slog.LevelInfo + 2
+
+// SlogHandler is a slog.Handler which writes records to the same log
+// as Log does (i.e. it honours config/log/level, config/log/type,
+// config/log/output and config/log/file).
+//
+// Attributes are rendered in logfmt after the message, attributes in
+// groups get group names as key prefix: "group.key=value".
+//
+// To make it default for slog and log packages use:
+//
+//	slog.SetDefault(slog.New(narada.NewSlogHandler()))
+type SlogHandler struct {
+	p      *Project // nil means default project
+	group  string // group prefix for keys, like "g1.g2."
+	fields string // already rendered in logfmt
+}
+
+// NewSlogHandler returns new SlogHandler.
+func NewSlogHandler() *SlogHandler {
+	return defaultProject.NewSlogHandler()
+}
+
+// NewSlogHandler returns new SlogHandler which writes to project's log.
+func (p *Project) NewSlogHandler() *SlogHandler {
+	return &SlogHandler{p: p}
+}
+
+// SlogLevel returns LogLevel corresponding to slog level.
+func SlogLevel(level slog.Level) LogLevel {
+	switch {
+	case level < slog.LevelInfo:
+		return LogDEBUG
+	case level < SlogLevelNotice:
+		return LogINFO
+	case level < slog.LevelWarn:
+		return LogNOTICE
+	case level < slog.LevelError:
+		return LogWARN
+	}
+	return LogERR
+}
+
+// Enabled implements slog.Handler.
+func (h *SlogHandler) Enabled(_ context.Context, level slog.Level) bool {
+	return h.project().getLog().level <= SlogLevel(level)
+}
+
+// Handle implements slog.Handler.
+func (h *SlogHandler) Handle(_ context.Context, r slog.Record) error {
+	var b strings.Builder
+	b.WriteString(h.fields)
+	r.Attrs(func(a slog.Attr) bool {
+		appendAttr(&b, h.group, a)
+		return true
+	})
+	msg := r.Message
+	if b.Len() > 0 {
+		msg += " " + b.String()
+	}
+	h.project().writeLog(SlogLevel(r.Level), msg, stderrFallback)
+	return nil
+}
+
+// WithAttrs implements slog.Handler.
+func (h *SlogHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	if len(attrs) == 0 {
+		return h
+	}
+	h2 := *h
+	var b strings.Builder
+	b.WriteString(h.fields)
+	for _, a := range attrs {
+		appendAttr(&b, h.group, a)
+	}
+	h2.fields = b.String()
+	return &h2
+}
+
+// WithGroup implements slog.Handler.
+func (h *SlogHandler) WithGroup(name string) slog.Handler {
+	if name == "" {
+		return h
+	}
+	h2 := *h
+	h2.group += name + "."
+	return &h2
+}
+
+func (h *SlogHandler) project() *Project {
+	if h.p == nil {
+		return defaultProject
+	}
+	return h.p
+}
+
+func appendAttr(b *strings.Builder, group string, a slog.Attr) {
+	a.Value = a.Value.Resolve()
+	if a.Equal(slog.Attr{}) {
+		return
+	}
+	if a.Value.Kind() == slog.KindGroup {
+		if len(a.Value.Group()) == 0 {
+			return
+		}
+		if a.Key != "" {
+			group += a.Key + "."
+		}
+		for _, ga := range a.Value.Group() {
+			appendAttr(b, group, ga)
+		}
+		return
+	}
+	if b.Len() > 0 {
+		b.WriteByte(' ')
+	}
+	b.WriteString(logfmtKey(group + a.Key))
+	b.WriteByte('=')
+	b.WriteString(logfmtValue(a.Value.Any()))
+}
+
+// stderrFallback is used instead of stdlogFallback because when
+// SlogHandler is installed as slog default then log package output is
+// sent to SlogHandler.
+func stderrFallback(level LogLevel, msg string) {
+	fmt.Fprintln(os.Stderr, level.String()+": "+strings.TrimRight(msg, "\n"))
+}
@@ -0,0 +1,86 @@
+package narada
+
+import (
+	"context"
+	"io/ioutil"
+	"log/slog"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSlogLevel(t *testing.T) {
+	cases := []struct {
+		level slog.Level
+		want  LogLevel
+	}{
+		{slog.LevelDebug - 1, LogDEBUG},
+		{slog.LevelDebug, LogDEBUG},
+		{slog.LevelInfo - 1, LogDEBUG},
+		{slog.LevelInfo, LogINFO},
+		{slog.LevelInfo + 1, LogINFO},
+		{SlogLevelNotice, LogNOTICE},
+		{slog.LevelWarn - 1, LogNOTICE},
+		{slog.LevelWarn, LogWARN},
+		{slog.LevelError - 1, LogWARN},
+		{slog.LevelError, LogERR},
+		{slog.LevelError + 4, LogERR},
+	}
+	for _, c := range cases {
+		if got := SlogLevel(c.level); got != c.want {
+			t.Errorf("SlogLevel(%v) = %v, want %v", c.level, got, c.want)
+		}
+	}
+}
+
+func TestSlogHandler(t *testing.T) {
+	origOpen := defaultProject.open
+	defer func() {
+		defaultProject.open = origOpen
+		InitLogError = defaultProject.initLog()
+	}()
+	if err := os.Remove("var/slog.txt"); err != nil && !os.IsNotExist(err) {
+		t.Fatal(err)
+	}
+	FakeConfig(map[string]string{"log/type": "file", "log/file": "var/slog.txt", "log/level": "NOTICE"})
+	if err := defaultProject.initLog(); err != nil {
+		t.Fatalf("defaultProject.initLog(), err = %v", err)
+	}
+
+	h := NewSlogHandler()
+	ctx := context.Background()
+	if h.Enabled(ctx, slog.LevelInfo) {
+		t.Errorf("Enabled(Info) = true, want false")
+	}
+	if !h.Enabled(ctx, SlogLevelNotice) {
+		t.Errorf("Enabled(Notice) = false, want true")
+	}
+
+	l := slog.New(h)
+	l.Info("skipped")
+	l.Log(ctx, SlogLevelNotice, "notice", "a", 1)
+	l.Warn("warn", "s", "x y", slog.Group("g", "b", true, slog.Group("", "c", "q\"")))
+	l2 := l.With("req", 42).WithGroup("").WithGroup("grp")
+	l2.Error("error", "a", nil, slog.Group("empty"))
+	l2.With("k", "v").WithGroup("sub").Error("nested", "x", 1)
+
+	want := []string{
+		"NOTICE: notice a=1",
+		`WARN: warn s="x y" g.b=true g.c="q\""`,
+		"ERR: error req=42 grp.a=null",
+		"ERR: nested req=42 grp.k=v grp.sub.x=1",
+	}
+	buf, err := ioutil.ReadFile("var/slog.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSuffix(string(buf), "\n"), "\n")
+	prefix := regexp.MustCompile(`\A.*?\]: `)
+	for i := range lines {
+		lines[i] = prefix.ReplaceAllString(lines[i], "")
+	}
+	if strings.Join(lines, "\n") != strings.Join(want, "\n") {
+		t.Errorf("log:\n%s\nwant:\n%s", strings.Join(lines, "\n"), strings.Join(want, "\n"))
+	}
+}
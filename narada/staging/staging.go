@@ -4,131 +4,108 @@
 // even before narada/bootstrap import in main package.
 //
 // Importing this package will have effect only under `go test`:
-// current directory will be changed to temporary Narada project directory.
+// current directory will be changed to temporary Narada project directory
+// (and $NARADA_DIR will be set to it).
 // To cleanup that directory after tests call TearDown like this:
 //
 //   func TestMain(m *testing.M) { os.Exit(staging.TearDown(m.Run())) }
+//
+// Tests which need own project (e.g. to run in parallel with different
+// project state) should use New.
+//
+// Project contains default dirs and files (config/log/*, config/mysql/*,
+// var/, etc.) overlaid with fixtures from testdata/project/ directory
+// and testdata/project.txtar archive (if they exist). After that
+// testdata/staging.setup is executed (if exists) and hooks registered by
+// OnSetup are called.
 package staging
 
 import (
-	"flag"
-	"io/ioutil"
 	"log"
-	"os"
-	"os/exec"
-	"strings"
+	"path/filepath"
+	"testing"
+
+	"github.com/powerman/narada-go/narada"
+	"github.com/powerman/narada-go/narada/internal/stagingdir"
 )
 
 var (
 	// BaseDir is an original directory (where test was executed).
-	BaseDir string
+	BaseDir = stagingdir.BaseDir
 	// WorkDir is a current directory (with temporary narada project).
-	WorkDir string
-)
-
-var (
-	dirs = []string{
-		".backup",
-		"config",
-		"config/backup",
-		"config/log",
-		"config/mysql",
-		"config/mysql/dump",
-		"config/qmail",
-		"tmp",
-		"var",
-		"var/log",
-		"var/use",
-		"var/mysql",
-		"var/qmail",
-	}
-	files = []struct{ name, data string }{
-		{"config/backup/exclude", "./.backup/*\n./.lock*\n./tmp/*\n./.release/*\n"},
-		{"config/log/level", "DEBUG"},
-		{"config/log/type", "file"},
-		{"config/log/file", "/dev/stdout"},
-		{"config/mysql/host", ""},
-		{"config/mysql/port", "3306"},
-		{"config/mysql/db", ""},
-		{"config/mysql/login", ""},
-		{"config/mysql/pass", ""},
-		{"config/mysql/dump/empty", ""},
-		{"config/mysql/dump/ignore", ""},
-		{"config/mysql/dump/incremental", ""},
-	}
+	WorkDir = stagingdir.WorkDir
 )
 
-func init() {
-	if flag.Lookup("test.v") != nil || strings.HasSuffix(os.Args[0], ".test") {
-		err := setUp()
-		if err != nil {
-			log.Fatal(err)
-		}
-	}
+// TearDown removes temporary Narada project directory and returns
+// exitCode.
+func TearDown(exitCode int) int {
+	return stagingdir.TearDown(exitCode)
 }
 
-func setUp() (err error) {
-	BaseDir, err = os.Getwd()
-	if err != nil {
-		return err
-	}
-	WorkDir, err = ioutil.TempDir("", "narada-staging.")
-	if err != nil {
-		return err
-	}
-	err = os.Chdir(WorkDir)
+// OnSetup registers hook which will be called with project directory
+// for each project created by New and also for WorkDir (immediately,
+// because it's already created). It should be called from init() or
+// TestMain. Terminates program if hook fails for WorkDir.
+//
+// Returned remove unregisters hook. Use it (or pass hook to New instead)
+// if hook is registered by a test, to not affect other tests.
+func OnSetup(hook func(dir string) error) (remove func()) {
+	remove, err := stagingdir.OnSetup(hook)
 	if err != nil {
-		return err
-	}
-
-	for _, dir := range dirs {
-		err = os.Mkdir(dir, 0777)
-		if err != nil {
-			return err
-		}
-	}
-	for _, file := range files {
-		err = ioutil.WriteFile(file.name, []byte(file.data), 0666)
-		if err != nil {
-			return err
-		}
+		log.Fatalf("staging: %v", err)
 	}
+	return remove
+}
 
-	custom := BaseDir + "/testdata/staging.setup"
-	if _, err = os.Stat(custom); err == nil {
-		cmd := exec.Command(custom, BaseDir)
-		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-		err = cmd.Run()
-		if err != nil {
-			return err
-		}
+// Tree returns hook which copies contents of directory src (like
+// "testdata/other-project", relative to BaseDir) into project directory.
+func Tree(src string) func(dir string) error {
+	if !filepath.IsAbs(src) {
+		src = filepath.Join(BaseDir, src)
 	}
+	return func(dir string) error { return stagingdir.CopyTree(dir, src) }
+}
 
-	return nil
+// Txtar returns hook which writes files from txtar archive (see
+// golang.org/x/tools/txtar) into project directory, for example:
+//
+//	staging.Txtar(`
+//	-- config/mysql/db --
+//	test
+//	-- var/data --
+//	`)
+func Txtar(archive string) func(dir string) error {
+	return func(dir string) error { return stagingdir.ExtractTxtar(dir, []byte(archive)) }
 }
 
-func TearDown(exitCode int) int {
-	var err error
-	custom := BaseDir + "/testdata/staging.teardown"
-	if _, err = os.Stat(custom); err == nil {
-		cmd := exec.Command(custom, BaseDir)
-		cmd.Stdout, cmd.Stderr = os.Stdout, os.Stderr
-		err = cmd.Run()
-		if err != nil {
-			log.Print(err)
-			return exitCode
+// New returns new Narada project in temporary directory set up in same
+// way as WorkDir was and then changed by hooks (see Tree and Txtar).
+// Project directory will be removed (after running
+// testdata/staging.teardown) when test finishes.
+//
+// Unlike WorkDir it doesn't change current directory or environment, so
+// it's safe to use in parallel tests. Returned project must be used
+// using narada.Project methods, package-level narada functions will
+// still use WorkDir.
+func New(t testing.TB, hooks ...func(dir string) error) *narada.Project {
+	t.Helper()
+	dir := t.TempDir()
+	t.Cleanup(func() {
+		if err := stagingdir.Run("staging.teardown", dir); err != nil {
+			t.Errorf("staging: %v", err)
 		}
+	})
+	if err := stagingdir.SetUp(dir); err != nil {
+		t.Fatalf("staging: %v", err)
 	}
-
-	err = os.Chdir(BaseDir)
-	if err != nil {
-		log.Print(err)
-		return exitCode
+	for _, hook := range hooks {
+		if err := hook(dir); err != nil {
+			t.Fatalf("staging: %v", err)
+		}
 	}
-	err = os.RemoveAll(WorkDir)
+	p, err := narada.NewProject(dir)
 	if err != nil {
-		log.Print(err)
-		return exitCode
+		t.Fatalf("staging: %v", err)
 	}
-	return exitCode
+	return p
 }
@@ -0,0 +1,153 @@
+package staging
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/powerman/narada-go/narada"
+	"github.com/powerman/narada-go/narada/internal/testutil"
+)
+
+func TestNew(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if wd != WorkDir || os.Getenv("NARADA_DIR") != WorkDir {
+		t.Errorf("Getwd() = %q, $NARADA_DIR = %q, want %q", wd, os.Getenv("NARADA_DIR"), WorkDir)
+	}
+
+	var roots []string
+	t.Run("group", func(t *testing.T) {
+		for _, name := range []string{"one", "two"} {
+			name := name
+			p := New(t)
+			roots = append(roots, p.Root())
+			t.Run(name, func(t *testing.T) {
+				t.Parallel()
+				testProject(t, p, name)
+			})
+		}
+	})
+	if roots[0] == roots[1] {
+		t.Errorf("New() returns same root %q", roots[0])
+	}
+	for _, root := range roots {
+		if _, err := os.Stat(root); !os.IsNotExist(err) {
+			t.Errorf("%s is not removed, err = %v", root, err)
+		}
+	}
+}
+
+func testProject(t *testing.T, p *narada.Project, name string) {
+	t.Helper()
+	if p.Root() == WorkDir {
+		t.Errorf("Root() = WorkDir")
+	}
+	if got, _, err := p.LookupConfigLine("log/level"); err != nil || got != "DEBUG" {
+		t.Errorf("LookupConfigLine(log/level) = %q, %v", got, err)
+	}
+	if err := p.SetConfigLine("name", name); err != nil {
+		t.Fatal(err)
+	}
+	if got, _, err := p.LookupConfigLine("name"); err != nil || got != name {
+		t.Errorf("LookupConfigLine(name) = %q, %v, want %q", got, err, name)
+	}
+	lock, err := p.ExclusiveLock(time.Second)
+	if err != nil {
+		t.Fatalf("ExclusiveLock(), err = %v", err)
+	}
+	time.Sleep(10 * time.Millisecond) // Let other test try to lock.
+	if err = lock.UnLock(); err != nil {
+		t.Errorf("UnLock(), err = %v", err)
+	}
+}
+
+func TestFixtures(t *testing.T) {
+	for _, dir := range []string{WorkDir, New(t).Root()} {
+		for name, want := range map[string]string{
+			"var/fixture":          "from tree\n",
+			"config/mysql/db":      "fixture\n",
+			"config/mysql/port":    "3306",
+			"config/staging/empty": "",
+		} {
+			if got := testutil.ReadFile(t, filepath.Join(dir, name)); got != want {
+				t.Errorf("%s: %s = %q, want %q", dir, name, got, want)
+			}
+		}
+	}
+
+	var calls []string
+	remove := OnSetup(func(dir string) error {
+		calls = append(calls, dir)
+		return ioutil.WriteFile(filepath.Join(dir, "var", "hook"), []byte("hook"), 0644)
+	})
+	defer remove()
+	if len(calls) != 1 || calls[0] != WorkDir {
+		t.Errorf("OnSetup() called hook for %q, want %q", calls, WorkDir)
+	}
+
+	p := New(t, Tree("testdata/other"), Txtar(`
+-- config/mysql/db --
+inline
+-- var/hook --
+overwritten
+`))
+	if len(calls) != 2 || calls[1] != p.Root() {
+		t.Errorf("New() called hook for %q, want %q", calls, p.Root())
+	}
+	for name, want := range map[string]string{
+		"var/fixture":      "from tree\n",
+		"config/mysql/db":  "inline\n",
+		"config/log/level": "INFO\n",
+		"var/hook":         "overwritten\n",
+	} {
+		if got := testutil.ReadFile(t, p.Path(name)); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	remove()
+	New(t)
+	if len(calls) != 2 {
+		t.Errorf("New() called removed hook for %q", calls[2:])
+	}
+}
+
+func TestTxtar(t *testing.T) {
+	cases := []struct {
+		archive string
+		want    map[string]string
+		wantErr bool
+	}{
+		{"", map[string]string{}, false},
+		{"comment\n-- a --\n", map[string]string{"a": ""}, false},
+		{"-- a --\nA\n--b --\n-- dir/b --\nB", map[string]string{"a": "A\n--b --\n", "dir/b": "B\n"}, false},
+		{"-- ../a --\n", nil, true},
+		{"-- /a --\n", nil, true},
+	}
+	for _, c := range cases {
+		dir := t.TempDir()
+		err := Txtar(c.archive)(dir)
+		if (err != nil) != c.wantErr {
+			t.Errorf("Txtar(%q), err = %v", c.archive, err)
+		}
+		if c.wantErr {
+			continue
+		}
+		got := make(map[string]string)
+		_ = filepath.Walk(dir, func(path string, fi os.FileInfo, err error) error {
+			if err == nil && fi.Mode().IsRegular() {
+				got[path[len(dir)+1:]] = testutil.ReadFile(t, path)
+			}
+			return err
+		})
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("Txtar(%q) = %q, want %q", c.archive, got, c.want)
+		}
+	}
+}
@@ -2,19 +2,199 @@ package narada
 
 import (
 	"bytes"
+	"context"
+	"fmt"
 	"io/ioutil"
+	"strconv"
+	"strings"
 )
 
 // Version returns project's version.
-func Version() (version string, err error) {
-	lock, err := SharedLock(0)
+func Version() (version string, err error) { return defaultProject.Version() }
+
+// Version works like package-level Version.
+func (p *Project) Version() (version string, err error) {
+	return p.VersionContext(context.Background())
+}
+
+// VersionContext works like Version but gets shared lock using
+// SharedLockContext(ctx), so it may be called while ctx carries lock (see
+// WithSharedLock and WithExclusiveLock).
+func VersionContext(ctx context.Context) (version string, err error) {
+	return defaultProject.VersionContext(ctx)
+}
+
+// VersionContext works like package-level VersionContext.
+func (p *Project) VersionContext(ctx context.Context) (version string, err error) {
+	lock, err := p.SharedLockContext(ctx)
 	if err != nil {
 		return
 	}
 	defer lock.UnLock()
-	buf, err := ioutil.ReadFile("VERSION")
+	buf, err := ioutil.ReadFile(p.Path("VERSION"))
 	if err != nil {
 		return
 	}
 	return string(bytes.TrimRight(buf, " \r\n")), nil
 }
+
+// ProjectVersion is a parsed project version in format
+// "MAJOR[.MINOR[.PATCH]][-PRERELEASE][+BUILD]", for example
+// "1.2.3+example-1234567890".
+type ProjectVersion struct {
+	Major      int
+	Minor      int
+	Patch      int
+	PreRelease string // Dot-separated identifiers, like "rc.1".
+	Build      string // Narada use "name-timestamp" here.
+}
+
+// ParseProjectVersion parses version. It is more tolerant than SemVer:
+// it allows leading zeroes and missing minor and patch parts (they
+// default to 0) and any non-empty build metadata.
+func ParseProjectVersion(s string) (ProjectVersion, error) {
+	var v ProjectVersion
+	core := strings.TrimSpace(s)
+	if i := strings.IndexByte(core, '+'); i >= 0 {
+		core, v.Build = core[:i], core[i+1:]
+		if v.Build == "" {
+			return ProjectVersion{}, fmt.Errorf("invalid version: %q", s)
+		}
+	}
+	if i := strings.IndexByte(core, '-'); i >= 0 {
+		core, v.PreRelease = core[:i], core[i+1:]
+		for _, id := range strings.Split(v.PreRelease, ".") {
+			if id == "" {
+				return ProjectVersion{}, fmt.Errorf("invalid version: %q", s)
+			}
+		}
+	}
+	parts := strings.Split(core, ".")
+	nums := []*int{&v.Major, &v.Minor, &v.Patch}
+	if len(parts) > len(nums) {
+		return ProjectVersion{}, fmt.Errorf("invalid version: %q", s)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || part[0] == '+' {
+			return ProjectVersion{}, fmt.Errorf("invalid version: %q", s)
+		}
+		*nums[i] = n
+	}
+	return v, nil
+}
+
+// CurrentVersion returns parsed project's version.
+func CurrentVersion() (ProjectVersion, error) { return defaultProject.CurrentVersion() }
+
+// CurrentVersion works like package-level CurrentVersion.
+func (p *Project) CurrentVersion() (ProjectVersion, error) {
+	s, err := p.Version()
+	if err != nil {
+		return ProjectVersion{}, err
+	}
+	return ParseProjectVersion(s)
+}
+
+// VersionBefore reports is project's version older than given version.
+func VersionBefore(version string) (bool, error) { return defaultProject.VersionBefore(version) }
+
+// VersionBefore works like package-level VersionBefore.
+func (p *Project) VersionBefore(version string) (bool, error) {
+	cur, other, err := p.currentAnd(version)
+	return err == nil && cur.Less(other), err
+}
+
+// VersionAtLeast reports is project's version same or newer than given
+// version.
+func VersionAtLeast(version string) (bool, error) { return defaultProject.VersionAtLeast(version) }
+
+// VersionAtLeast works like package-level VersionAtLeast.
+func (p *Project) VersionAtLeast(version string) (bool, error) {
+	cur, other, err := p.currentAnd(version)
+	return err == nil && !cur.Less(other), err
+}
+
+func (p *Project) currentAnd(version string) (cur, other ProjectVersion, err error) {
+	if other, err = ParseProjectVersion(version); err != nil {
+		return
+	}
+	cur, err = p.CurrentVersion()
+	return
+}
+
+// String returns version in canonical form.
+func (v ProjectVersion) String() string {
+	s := fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+	if v.PreRelease != "" {
+		s += "-" + v.PreRelease
+	}
+	if v.Build != "" {
+		s += "+" + v.Build
+	}
+	return s
+}
+
+// Compare returns -1, 0 or +1 if v is older, same or newer than w
+// using SemVer precedence rules (Build is ignored).
+func (v ProjectVersion) Compare(w ProjectVersion) int {
+	if c := compareInt(v.Major, w.Major); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Minor, w.Minor); c != 0 {
+		return c
+	}
+	if c := compareInt(v.Patch, w.Patch); c != 0 {
+		return c
+	}
+	switch {
+	case v.PreRelease == w.PreRelease:
+		return 0
+	case v.PreRelease == "":
+		return 1
+	case w.PreRelease == "":
+		return -1
+	}
+	vids, wids := strings.Split(v.PreRelease, "."), strings.Split(w.PreRelease, ".")
+	for i := 0; i < len(vids) && i < len(wids); i++ {
+		if c := comparePreRelease(vids[i], wids[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInt(len(vids), len(wids))
+}
+
+// Less reports is v older than w.
+func (v ProjectVersion) Less(w ProjectVersion) bool {
+	return v.Compare(w) < 0
+}
+
+func comparePreRelease(a, b string) int {
+	an, aerr := strconv.ParseUint(a, 10, 64)
+	bn, berr := strconv.ParseUint(b, 10, 64)
+	switch {
+	case aerr == nil && berr == nil:
+		switch {
+		case an < bn:
+			return -1
+		case an > bn:
+			return 1
+		}
+		return 0
+	case aerr == nil:
+		return -1
+	case berr == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInt(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
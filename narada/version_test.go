@@ -23,9 +23,105 @@ func TestVersion(t *testing.T) {
 	}
 	defer os.Chmod("VERSION", 0644)
 
-	wanterr := &os.PathError{Op: "open", Path: "VERSION", Err: syscall.EACCES}
+	wanterr := &os.PathError{Op: "open", Path: Path("VERSION"), Err: syscall.EACCES}
 	_, err = Version()
 	if fmt.Sprintf("%#v", err) != fmt.Sprintf("%#v", wanterr) {
 		t.Errorf("Version(), err = %#v, want %#v", err, wanterr)
 	}
 }
+
+func TestParseProjectVersion(t *testing.T) {
+	cases := []struct {
+		s       string
+		want    ProjectVersion
+		wantStr string
+		wantErr bool
+	}{
+		{"1.2.3+example-1234567890", ProjectVersion{1, 2, 3, "", "example-1234567890"}, "1.2.3+example-1234567890", false},
+		{"0.0.000\n", ProjectVersion{}, "0.0.0", false},
+		{"1", ProjectVersion{Major: 1}, "1.0.0", false},
+		{"1.2", ProjectVersion{Major: 1, Minor: 2}, "1.2.0", false},
+		{"1.2.3-rc.1", ProjectVersion{1, 2, 3, "rc.1", ""}, "1.2.3-rc.1", false},
+		{"1.2.3-rc.1+b", ProjectVersion{1, 2, 3, "rc.1", "b"}, "1.2.3-rc.1+b", false},
+		{"", ProjectVersion{}, "", true},
+		{"v1.2.3", ProjectVersion{}, "", true},
+		{"1.2.3.4", ProjectVersion{}, "", true},
+		{"1.-2.3", ProjectVersion{}, "", true},
+		{"1.+2.3", ProjectVersion{}, "", true},
+		{"1.2.3-", ProjectVersion{}, "", true},
+		{"1.2.3-rc..1", ProjectVersion{}, "", true},
+		{"1.2.3+", ProjectVersion{}, "", true},
+	}
+	for _, c := range cases {
+		v, err := ParseProjectVersion(c.s)
+		if (err != nil) != c.wantErr {
+			t.Errorf("ParseProjectVersion(%q), err = %v", c.s, err)
+		}
+		if v != c.want {
+			t.Errorf("ParseProjectVersion(%q) = %#v, want %#v", c.s, v, c.want)
+		}
+		if err == nil && v.String() != c.wantStr {
+			t.Errorf("ParseProjectVersion(%q).String() = %q, want %q", c.s, v.String(), c.wantStr)
+		}
+	}
+}
+
+func TestProjectVersionCompare(t *testing.T) {
+	// In ascending order, equal versions are in same group.
+	groups := [][]string{
+		{"0.9"},
+		{"1.0.0-1"},
+		{"1.0.0-2"},
+		{"1.0.0-10"},
+		{"1.0.0-alpha"},
+		{"1.0.0-alpha.1"},
+		{"1.0.0-alpha.beta"},
+		{"1.0.0-beta"},
+		{"1.0.0", "1", "1.0.0+example-1234567890"},
+		{"1.2.3", "1.2.3+example-1"},
+		{"1.10.0"},
+		{"2.0.0"},
+	}
+	for i := range groups {
+		for j := range groups {
+			for _, a := range groups[i] {
+				for _, b := range groups[j] {
+					v, _ := ParseProjectVersion(a)
+					w, _ := ParseProjectVersion(b)
+					want := compareInt(i, j)
+					if c := v.Compare(w); c != want {
+						t.Errorf("%q.Compare(%q) = %d, want %d", a, b, c, want)
+					}
+					if v.Less(w) != (want < 0) {
+						t.Errorf("%q.Less(%q) = %v", a, b, v.Less(w))
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestVersionBefore(t *testing.T) {
+	cases := []struct {
+		version string
+		before  bool
+		wantErr bool
+	}{
+		{"1.2.2", false, false},
+		{"1.2.3", false, false},
+		{"1.2.3+example-1", false, false},
+		{"1.2.4-rc1", true, false},
+		{"2", true, false},
+		{"bad", false, true},
+	}
+	for _, c := range cases {
+		before, err := VersionBefore(c.version)
+		if before != c.before || (err != nil) != c.wantErr {
+			t.Errorf("VersionBefore(%q) = %v, %v", c.version, before, err)
+		}
+		atLeast, err := VersionAtLeast(c.version)
+		if atLeast != (!c.before && !c.wantErr) || (err != nil) != c.wantErr {
+			t.Errorf("VersionAtLeast(%q) = %v, %v", c.version, atLeast, err)
+		}
+	}
+}